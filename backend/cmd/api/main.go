@@ -1,12 +1,24 @@
 package main
 
 import (
+	"backend/internal/admin"
+	"backend/internal/audit"
 	"backend/internal/auth"
 	"backend/internal/config"
 	"backend/internal/database"
+	"backend/internal/email"
+	"backend/internal/logging"
+	"backend/internal/market"
+	"backend/internal/middleware"
+	"backend/internal/tracing"
+	"backend/internal/user"
+	"backend/internal/version"
 	"context"
 	"errors"
+	"expvar"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,7 +26,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 )
 
@@ -48,86 +60,138 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// very basic logger
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// structured JSON logger, log.Printf calls go through it as well
+	logging.Setup(cfg.LogLevel)
 	log.Println("Logger initialized.")
+	log.Printf("PaperTrading API version %s (commit %s, built %s)", version.Version, version.Commit, version.BuildTime)
 	if cfg.LogLevel == "debug" {
 		log.Println("Service starting with log level: DEBUG")
 	}
 
-	if err := database.InitPgxPool(ctx, cfg); err != nil {
+	shutdownTracing, err := tracing.Setup(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		// flush pending spans, bounded so a dead collector can't hang the exit
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+
+	// SIGINT/SIGTERM while still waiting for the database aborts the startup
+	initCtx, stopInit := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	err = database.InitPgxPool(initCtx, cfg)
+	stopInit()
+	if err != nil {
 		log.Fatalf("Failed to initialize PostgreSQL pool: %v", err)
 	}
 	// defer closing the pool when the application exits
 	defer database.ClosePgxPool()
 
+	// one line with everything an operator may want to double check, secrets are left out by Config.LogValue
+	slog.Info("effective configuration", slog.Any("config", cfg), slog.Int("dbMaxConns", int(database.PoolStats().MaxConns)))
+
 	// initialize pool and stores
 	dbPool := database.GetPool()
 	userStore := auth.NewUserStore(dbPool)
-	tokenStore := auth.NewTokenStore(dbPool)
+	tokenStore := auth.NewTokenStore(dbPool, cfg.MaxSessionsPerUser, cfg.RefreshTokenGrace)
+	auditStore := audit.NewStore(dbPool)
 
 	// initialize authService
-	authService := auth.NewAuthService(dbPool, userStore, tokenStore, cfg)
+	emailSender, err := email.NewSender(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up email: %v", err)
+	}
+	authService := auth.NewAuthService(dbPool, userStore, tokenStore, auditStore, emailSender, cfg)
 
 	// initialize authHandler
 	authHandler := auth.NewHandler(authService, cfg)
 
 	// initialize authMiddleware
-	authMiddleware := auth.NewMiddleware(authService)
+	authMiddleware := auth.NewMiddleware(authService, cfg)
+
+	// initialize adminHandler
+	adminHandler := admin.NewHandler(userStore, tokenStore, auditStore)
+
+	// initialize marketHandler
+	marketHandler := market.NewHandler(market.NewNYSECalendar(), cfg)
 
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(chimiddleware.RequestID)
+	r.Use(middleware.EchoRequestID)
+	r.Use(chimiddleware.RealIP)
+	r.Use(middleware.Tracing)
+	r.Use(audit.Middleware)         // after RealIP, so audit entries get the client IP
+	r.Use(middleware.RequestLogger) // after RequestID, so lines carry the request id
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(cfg.HTTPTimeout))
+	if cfg.CompressionEnabled {
+		r.Use(middleware.Compress(cfg.CompressionMinSize)) // inside Timeout, so a 504 is written after the compressed stream is closed
+	}
 
 	CORSMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:8001"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
 		AllowCredentials: true,
-		MaxAge:           300, // maximum value not ignored by any major browsers
+		MaxAge:           cfg.CORSMaxAge,
 	})
 
 	r.Use(CORSMiddleware.Handler)
 
 	// public routes
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Welcome to PaperTrading API"))
+		auth.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"service":    "PaperTrading API",
+			"apiVersion": version.APIVersion,
+			"build":      version.Get(),
+		})
 	})
 
 	// authentication routes
 	r.Route("/api/auth", func(ar chi.Router) {
+		ar.Use(middleware.Timeout(cfg.HTTPAuthTimeout)) // auth should answer fast, whatever the default is
 		ar.Post("/register", authHandler.Register)
 		ar.Post("/login", authHandler.Login)
 		ar.Post("/refresh-token", authHandler.RefreshToken)
 		ar.Post("/logout", authHandler.Logout)
+		ar.Post("/email/confirm", authHandler.ConfirmEmailChange)
+		ar.Post("/introspect", authHandler.Introspect) // service credentials, not user auth
+		ar.With(authMiddleware.Authenticate).Get("/verify", authHandler.Verify)
+	})
+
+	r.Get("/version", func(w http.ResponseWriter, r *http.Request) {
+		auth.RespondWithJSON(w, http.StatusOK, version.Get())
 	})
 
+	// market routes
+	r.Get("/api/market/status", marketHandler.Status)
+
 	// Protected routes
 	r.Group(func(protectedRouter chi.Router) {
 		protectedRouter.Use(authMiddleware.Authenticate) // apply the auth middleware
 
 		// get current user's info
-		protectedRouter.Get("/api/me", func(w http.ResponseWriter, r *http.Request) {
-			claims, ok := auth.GetUserClaims(r.Context())
-			if !ok {
-				// this should ideally not happen if middleware is working correctly
-				// and has already validated, but as a safeguard:
-				auth.RespondWithError(w, http.StatusUnauthorized, "Unable to retrieve user claims")
-				return
-			}
-			// respond with the claims:
-			auth.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
-				"message":   "Current user:",
-				"userId":    claims.UserID,
-				"email":     claims.Email,
-				"expiresAt": claims.ExpiresAt.Time.Format(time.RFC3339),
-			})
+		protectedRouter.Get("/api/me", authHandler.Me)
+		protectedRouter.Patch("/api/me/email", authHandler.ChangeEmail)
+		protectedRouter.Put("/api/me/display-name", authHandler.ChangeDisplayName)
+
+		// admin routes
+		protectedRouter.Route("/api/admin", func(adr chi.Router) {
+			adr.Use(authMiddleware.RequireRole(user.RoleAdmin))
+
+			adr.Get("/users", adminHandler.ListUsers)
+			adr.Put("/users/{id}/role", adminHandler.UpdateUserRole)
+			adr.Post("/users/{id}/revoke-sessions", adminHandler.RevokeSessions)
+			adr.Get("/audit", adminHandler.ListAuditLog)
+			adr.Get("/db/pool", adminHandler.DBPoolStats)
+			adr.Method(http.MethodGet, "/debug/vars", expvar.Handler()) // process metrics, e.g. panics_total
 		})
 
 		// TODO: other future protected routes:
@@ -136,8 +200,12 @@ func main() {
 	})
 
 	server := &http.Server{
-		Addr:    ":" + cfg.AppPort,
 		Handler: r,
+		// the write timeout must outlast the timeout middleware, or its 504 can't be written
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
 	}
 
 	// graceful shutdown
@@ -156,10 +224,50 @@ func main() {
 		}
 	}()
 
-	log.Printf("Server starting on port %s\n", cfg.AppPort)
-	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	listener, err := listen(cfg)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	// Shutdown closes the listener, which also removes the unix socket file
+	if cfg.TLSEnabled() {
+		log.Printf("Server starting with TLS on %s %s\n", listener.Addr().Network(), listener.Addr())
+		err = server.ServeTLS(listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		log.Printf("Server starting on %s %s\n", listener.Addr().Network(), listener.Addr())
+		err = server.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 
 	log.Println("Server exited gracefully")
 }
+
+// listen opens the unix socket at cfg.AppSocket when set, and a TCP listener on
+// cfg.AppHost:cfg.AppPort otherwise.
+func listen(cfg *config.Config) (net.Listener, error) {
+	if cfg.AppSocket == "" {
+		return net.Listen("tcp", net.JoinHostPort(cfg.AppHost, cfg.AppPort))
+	}
+
+	// a socket file left behind by a crash would make the bind fail, only remove actual sockets
+	if info, err := os.Lstat(cfg.AppSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(cfg.AppSocket + " exists and is not a socket")
+		}
+		if err := os.Remove(cfg.AppSocket); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", cfg.AppSocket)
+	if err != nil {
+		return nil, err
+	}
+	// let a reverse proxy in the same group connect
+	if err := os.Chmod(cfg.AppSocket, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,259 @@
+package admin
+
+import (
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/pagination"
+	"backend/internal/user"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Handler holds dependencies for admin HTTP handlers.
+// every route served by it must be mounted behind Authenticate and RequireRole(user.RoleAdmin).
+type Handler struct {
+	us    *auth.UserStore
+	ts    *auth.TokenStore
+	audit *audit.Store
+}
+
+// NewHandler creates a new admin handler.
+func NewHandler(us *auth.UserStore, ts *auth.TokenStore, as *audit.Store) *Handler {
+	if us == nil {
+		log.Fatal("Admin Handler: UserStore cannot be nil")
+	}
+	if ts == nil {
+		log.Fatal("Admin Handler: TokenStore cannot be nil")
+	}
+	if as == nil {
+		log.Fatal("Admin Handler: audit Store cannot be nil")
+	}
+	return &Handler{us: us, ts: ts, audit: as}
+}
+
+// --- Request/Response
+
+// UserSummary is the admin view of a user. it never carries the password hash.
+type UserSummary struct {
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"emailVerified"`
+	DisplayName   string    `json:"displayName"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func toUserSummary(u *user.User) UserSummary {
+	return UserSummary{
+		ID:            u.ID,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		CreatedAt:     u.CreatedAt,
+	}
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// --- HTTP Handlers
+
+// ListUsers returns a page of users, optionally filtered by an email prefix.
+// GET /api/admin/users?search=&limit=&offset=
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		auth.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := auth.ListUsersFilter{
+		Search: r.URL.Query().Get("search"),
+	}
+
+	users, total, err := h.us.ListUsers(r.Context(), filter, page)
+	if err != nil {
+		if auth.ClientCanceled(r, err) {
+			return
+		}
+		log.Printf("Admin list users error: %v", err)
+		if database.IsQueryTimeout(err) {
+			auth.RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			auth.RespondWithError(w, http.StatusInternalServerError, "Failed to list users")
+		}
+		return
+	}
+
+	summaries := make([]UserSummary, 0, len(users))
+	for i := range users {
+		summaries = append(summaries, toUserSummary(&users[i]))
+	}
+
+	auth.RespondWithJSON(w, http.StatusOK, pagination.NewResponse(summaries, total, page))
+}
+
+// UpdateUserRole changes the role of the target user.
+// the target's refresh tokens are revoked so the new role is picked up on their next login.
+// PUT /api/admin/users/{id}/role
+func (h *Handler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := auth.RequireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		auth.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := auth.DecodeJSON(r, &req); err != nil {
+		auth.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !user.IsValidRole(req.Role) {
+		auth.RespondWithError(w, http.StatusBadRequest, "Role must be one of: user, admin")
+		return
+	}
+
+	updatedUser, err := h.us.UpdateRole(r.Context(), targetID, req.Role)
+	if err != nil {
+		if auth.ClientCanceled(r, err) {
+			return
+		}
+		if errors.Is(err, auth.ErrUserNotFound) {
+			auth.RespondWithError(w, http.StatusNotFound, "User not found")
+		} else if errors.Is(err, auth.ErrLastAdmin) {
+			auth.RespondWithError(w, http.StatusConflict, "Cannot demote the last remaining admin")
+		} else if database.IsQueryTimeout(err) {
+			auth.RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			log.Printf("Admin role update error for user %s: %v", targetID, err)
+			auth.RespondWithError(w, http.StatusInternalServerError, "Failed to update role")
+		}
+		return
+	}
+
+	// the role is carried in the access token, so force the target to log in again.
+	// the role itself is already changed, so a failure here is logged but not returned.
+	if _, err := h.ts.DeleteUserRefreshTokens(r.Context(), targetID); err != nil {
+		log.Printf("WARNING: Failed to revoke refresh tokens of user %s after role change: %v", targetID, err)
+	}
+
+	log.Printf("Role of user %s set to %s by admin %s", targetID, updatedUser.Role, actorID)
+	h.audit.Record(r.Context(), actorID, audit.ActionRoleChange, map[string]any{
+		"targetId": targetID,
+		"role":     updatedUser.Role,
+	})
+	auth.RespondWithJSON(w, http.StatusOK, toUserSummary(updatedUser))
+}
+
+// RevokeSessions deletes all refresh tokens of the target user, forcing them to log in again
+// everywhere once their current access tokens expire. it answers with the number of active sessions revoked.
+// POST /api/admin/users/{id}/revoke-sessions
+func (h *Handler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := auth.RequireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		auth.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if _, err := h.us.FindUserByIDInDB(r.Context(), targetID); err != nil {
+		if auth.ClientCanceled(r, err) {
+			return
+		}
+		if errors.Is(err, auth.ErrUserNotFound) {
+			auth.RespondWithError(w, http.StatusNotFound, "User not found")
+		} else if database.IsQueryTimeout(err) {
+			auth.RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			log.Printf("Admin revoke sessions lookup error for user %s: %v", targetID, err)
+			auth.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		}
+		return
+	}
+
+	revoked, err := h.ts.DeleteUserRefreshTokens(r.Context(), targetID)
+	if err != nil {
+		if auth.ClientCanceled(r, err) {
+			return
+		}
+		if database.IsQueryTimeout(err) {
+			auth.RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			log.Printf("Admin revoke sessions error for user %s: %v", targetID, err)
+			auth.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		}
+		return
+	}
+
+	log.Printf("Sessions of user %s revoked by admin %s", targetID, actorID)
+	h.audit.Record(r.Context(), actorID, audit.ActionSessionsRevoked, map[string]any{
+		"targetId": targetID,
+		"revoked":  revoked,
+	})
+	auth.RespondWithJSON(w, http.StatusOK, RevokeSessionsResponse{Revoked: revoked})
+}
+
+// ListAuditLog returns a page of audit entries, newest first, optionally filtered by actor and action.
+// GET /api/admin/audit?actorId=&action=&limit=&offset=
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		auth.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := audit.ListFilter{
+		Action: r.URL.Query().Get("action"),
+	}
+	if raw := r.URL.Query().Get("actorId"); raw != "" {
+		filter.ActorID, err = uuid.Parse(raw)
+		if err != nil {
+			auth.RespondWithError(w, http.StatusBadRequest, "Invalid actorId")
+			return
+		}
+	}
+
+	entries, total, err := h.audit.List(r.Context(), filter, page)
+	if err != nil {
+		if auth.ClientCanceled(r, err) {
+			return
+		}
+		log.Printf("Admin list audit log error: %v", err)
+		if database.IsQueryTimeout(err) {
+			auth.RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			auth.RespondWithError(w, http.StatusInternalServerError, "Failed to list audit log")
+		}
+		return
+	}
+
+	auth.RespondWithJSON(w, http.StatusOK, pagination.NewResponse(entries, total, page))
+}
+
+// DBPoolStats returns the database connection pool statistics.
+// GET /api/admin/db/pool
+func (h *Handler) DBPoolStats(w http.ResponseWriter, r *http.Request) {
+	auth.RespondWithJSON(w, http.StatusOK, database.PoolStats())
+}
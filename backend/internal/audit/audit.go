@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"backend/internal/database"
+	"backend/internal/pagination"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// audited actions.
+const (
+	ActionLoginSuccess         = "login_success"
+	ActionLoginFailure         = "login_failure"
+	ActionLogout               = "logout"
+	ActionTokenRefresh         = "token_refresh"
+	ActionTokenRefreshRejected = "token_refresh_rejected"
+	ActionRoleChange           = "role_change"
+	ActionEmailChangeRequest   = "email_change_request"
+	ActionEmailChange          = "email_change"
+	ActionSessionsRevoked      = "sessions_revoked"
+)
+
+// recordTimeout bounds how long an audit insert may take once detached from the request.
+const recordTimeout = 5 * time.Second
+
+// Entry is a single audit log row.
+type Entry struct {
+	ID        uuid.UUID      `json:"id"`
+	ActorID   *uuid.UUID     `json:"actorId"` // nil when the actor is unknown (e.g. failed login for an unknown email)
+	Action    string         `json:"action"`
+	Metadata  map[string]any `json:"metadata"`
+	IP        string         `json:"ip,omitempty"`
+	UserAgent string         `json:"userAgent,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// Store persists and queries audit log entries.
+type Store struct {
+	db *pgxpool.Pool
+}
+
+func NewStore(db *pgxpool.Pool) *Store {
+	if db == nil {
+		log.Fatalf("Error: audit Store initialized with a nil DB pool.")
+	}
+	return &Store{db: db}
+}
+
+// Record writes an audit entry. actorID may be uuid.Nil when the actor is unknown.
+// the request IP and user agent are taken from ctx when the Middleware populated them.
+// failures are logged and never returned: auditing must not break the audited action.
+func (s *Store) Record(ctx context.Context, actorID uuid.UUID, action string, metadata map[string]any) {
+	info, _ := requestInfoFromContext(ctx)
+
+	// the insert outlives a client disconnect, the event already happened
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	var actor *uuid.UUID
+	if actorID != uuid.Nil {
+		actor = &actorID
+	}
+
+	query := `
+		insert into public.audit_log (actor_id, action, metadata, ip, user_agent)
+		values ($1, $2, $3, nullif($4, ''), nullif($5, ''))
+	`
+	if _, err := s.db.Exec(ctx, query, actor, action, metadata, info.ip, info.userAgent); err != nil {
+		database.LogQueryError(err, "Error recording audit event %s for actor %s: %v", action, actorID, err)
+	}
+}
+
+// ListFilter narrows down the entries returned by List. zero values match everything.
+type ListFilter struct {
+	ActorID uuid.UUID
+	Action  string
+}
+
+// List returns a page of audit entries, newest first, along with the total number matching the filter.
+func (s *Store) List(ctx context.Context, filter ListFilter, page pagination.Page) ([]Entry, int, error) {
+	ctx, done := database.StartQuery(ctx, "audit.Store.List")
+	defer done()
+
+	var actor *uuid.UUID
+	if filter.ActorID != uuid.Nil {
+		actor = &filter.ActorID
+	}
+	where := `
+		where ($1::uuid is null or a.actor_id = $1)
+		  and ($2 = '' or a.action = $2)
+	`
+
+	var total int
+	countQuery := `select count(*) from public.audit_log a ` + where
+	if err := s.db.QueryRow(ctx, countQuery, actor, filter.Action).Scan(&total); err != nil {
+		database.LogQueryError(err, "Error counting audit entries in DB: %v", err)
+		return nil, 0, fmt.Errorf("could not count audit entries: %w", err)
+	}
+
+	query := `
+		select a.id, a.actor_id, a.action, a.metadata, coalesce(a.ip, ''), coalesce(a.user_agent, ''), a.created_at
+		from public.audit_log a
+	` + where + `
+		order by a.created_at desc, a.id
+		limit $3 offset $4
+	`
+	rows, err := s.db.Query(ctx, query, actor, filter.Action, page.Limit, page.Offset)
+	if err != nil {
+		database.LogQueryError(err, "Error listing audit entries in DB: %v", err)
+		return nil, 0, fmt.Errorf("could not list audit entries: %w", err)
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		err := row.Scan(&e.ID, &e.ActorID, &e.Action, &e.Metadata, &e.IP, &e.UserAgent, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		database.LogQueryError(err, "Error scanning audit entries: %v", err)
+		return nil, 0, fmt.Errorf("could not list audit entries: %w", err)
+	}
+
+	return entries, total, nil
+}
+
+// --- Request info
+
+type contextKey string
+
+const requestInfoKey contextKey = "auditRequestInfo"
+
+type requestInfo struct {
+	ip        string
+	userAgent string
+}
+
+// Middleware stores the client IP and user agent in the request context so Record can attach them.
+// it should be mounted after chi's RealIP middleware so the IP reflects proxy headers.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		info := requestInfo{ip: ip, userAgent: r.UserAgent()}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
+	})
+}
+
+func requestInfoFromContext(ctx context.Context) (requestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey).(requestInfo)
+	return info, ok
+}
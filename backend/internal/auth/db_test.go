@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"backend/internal/audit"
+	"backend/internal/config"
+	"backend/internal/email"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestPool connects to DATABASE_URL and applies the schema, skipping the test when it's unset.
+// every test works on users of its own, so the database can be shared and reused.
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL not set, skipping database test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	schema, err := os.ReadFile("../database/db.sql")
+	if err != nil {
+		t.Fatalf("reading the schema: %v", err)
+	}
+	if _, err := pool.Exec(ctx, string(schema)); err != nil {
+		t.Fatalf("applying the schema: %v", err)
+	}
+	return pool
+}
+
+// newTestDBAuthService returns a service backed by the test database, with a cheap bcrypt cost.
+func newTestDBAuthService(t *testing.T, pool *pgxpool.Pool, refreshGrace time.Duration) *AuthService {
+	t.Helper()
+	cfg := &config.Config{
+		JWTSecret:              testJWTSecret,
+		JWTIssuer:              "PaperTradingApp",
+		JWTLeeway:              30 * time.Second,
+		JWTAllowedAlgs:         []string{"HS256"},
+		JWTExpiration:          15 * time.Minute,
+		RefreshTokenExpiration: 24 * time.Hour,
+		BcryptCost:             4,
+		PasswordMinLength:      8,
+		PasswordMaxLength:      72,
+	}
+	return NewAuthService(pool, NewUserStore(pool), NewTokenStore(pool, 0, refreshGrace),
+		audit.NewStore(pool), email.NewLogSender(), cfg)
+}
+
+// uniqueTestEmail returns an address no other test run uses.
+func uniqueTestEmail() string {
+	return "test-" + uuid.NewString() + "@example.com"
+}
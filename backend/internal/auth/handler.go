@@ -2,12 +2,19 @@ package auth
 
 import (
 	"backend/internal/config"
+	"backend/internal/database"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // Handler holds dependencies for authentication HTTP handlers.
@@ -40,10 +47,45 @@ type LoginUserRequest struct {
 	Password string `json:"password"`
 }
 
+type ChangeEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ChangeDisplayNameRequest struct {
+	DisplayName string `json:"displayName"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+// IntrospectionResponse follows RFC 7662: inactive tokens only carry `active: false`.
+type IntrospectionResponse struct {
+	Active    bool      `json:"active"`
+	TokenType string    `json:"token_type,omitempty"`
+	Sub       string    `json:"sub,omitempty"`
+	Iss       string    `json:"iss,omitempty"`
+	Exp       int64     `json:"exp,omitempty"`
+	Iat       int64     `json:"iat,omitempty"`
+	UserID    uuid.UUID `json:"uid,omitzero"` // omitempty never drops an array
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role,omitempty"`
+}
+
+// VerifyResponse summarizes the claims of a valid access token.
+type VerifyResponse struct {
+	Valid     bool      `json:"valid"`
+	UserID    uuid.UUID `json:"userId"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 // AuthResponse is used for successful authentication responses.
 type AuthResponse struct {
 	AccessToken string              `json:"accessToken"`
-	User        UserInfoForResponse `json:"user"` // using the struct defined in service.go
+	ExpiresAt   time.Time           `json:"expiresAt"` // access token expiry, the refresh token stays in the HttpOnly cookie
+	User        UserInfoForResponse `json:"user"`      // using the struct defined in service.go
 }
 
 // --- Helper Functions for HTTP responses
@@ -52,6 +94,68 @@ func RespondWithError(w http.ResponseWriter, code int, message string) {
 	RespondWithJSON(w, code, map[string]string{"error": message})
 }
 
+// error codes used in the structured error envelope.
+const (
+	ErrorCodeValidation  = "VALIDATION"
+	ErrorCodeRateLimited = "RATE_LIMITED"
+	ErrorCodeEmailTaken  = "EMAIL_TAKEN"
+	ErrorCodeNameTaken   = "DISPLAY_NAME_TAKEN"
+	ErrorCodeTimeout     = "TIMEOUT"
+	ErrorCodeInternal    = "INTERNAL"
+)
+
+// APIError is the structured form of the error envelope, for errors that need more than a message.
+// it is sent as {"error": {"code": ..., "message": ..., "fields": ..., "details": ...}}.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Details map[string]any    `json:"details,omitempty"`
+}
+
+func RespondWithAPIError(w http.ResponseWriter, code int, apiErr APIError) {
+	RespondWithJSON(w, code, map[string]APIError{"error": apiErr})
+}
+
+// RespondRateLimited writes the 429 response shared by every limiter: a Retry-After header
+// (in whole seconds, rounded up) and a RATE_LIMITED envelope carrying the limit, its window and
+// the retry delay, so clients can back off without guessing.
+func RespondRateLimited(w http.ResponseWriter, message string, limit int, window, retryAfter time.Duration) {
+	retrySeconds := int(math.Ceil(retryAfter.Seconds()))
+	if retrySeconds < 1 {
+		retrySeconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retrySeconds))
+	RespondWithAPIError(w, http.StatusTooManyRequests, APIError{
+		Code:    ErrorCodeRateLimited,
+		Message: message,
+		Details: map[string]any{
+			"limit":             limit,
+			"windowSeconds":     int(window.Seconds()),
+			"retryAfterSeconds": retrySeconds,
+		},
+	})
+}
+
+// ClientCanceled reports whether err comes from the client going away mid-request, which
+// cancels the request context and with it any store call in flight. it logs that at debug level:
+// it is a normal occurrence, not a failure. callers should return without writing a response,
+// nobody is left to read it.
+func ClientCanceled(r *http.Request, err error) bool {
+	if !errors.Is(err, context.Canceled) || r.Context().Err() == nil {
+		return false
+	}
+	slog.DebugContext(r.Context(), "request canceled by client", slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	return true
+}
+
+// RespondCreated writes a 201 with payload, and the URL of the new resource in the Location header.
+func RespondCreated(w http.ResponseWriter, location string, payload interface{}) {
+	w.Header().Set("Location", location)
+	RespondWithJSON(w, http.StatusCreated, payload)
+}
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
@@ -65,24 +169,64 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
+// --- Cookies
+
+// AccessTokenCookieName is the cookie carrying the access token when ACCESS_TOKEN_IN_COOKIE is enabled.
+const AccessTokenCookieName = "accessToken"
+
+// secureCookies reports whether cookies should carry the Secure attribute.
+// it must be true when served over HTTPS and false for local development on plain HTTP,
+// or the browser will ignore the cookies.
+func (h *Handler) secureCookies() bool {
+	// production is assumed to be behind an HTTPS proxy, and TLS termination here means HTTPS anyway
+	return h.cfg.AppEnv == "production" || h.cfg.TLSEnabled()
+}
+
+// setAccessTokenCookie also delivers the access token as an HttpOnly cookie when enabled.
+// the body still carries it, so header-based clients are unaffected.
+func (h *Handler) setAccessTokenCookie(w http.ResponseWriter, accessToken string, expiresAt time.Time) {
+	if !h.cfg.AccessTokenInCookie {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessTokenCookieName,
+		Value:    accessToken,
+		Path:     "/api", // sent to every api route, unlike the refresh token
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   h.secureCookies(),
+		SameSite: http.SameSiteStrictMode, // also what keeps cookie auth safe from CSRF
+	})
+}
+
+// clearAccessTokenCookie deletes the access token cookie, if the feature is enabled.
+func (h *Handler) clearAccessTokenCookie(w http.ResponseWriter) {
+	if !h.cfg.AccessTokenInCookie {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessTokenCookieName,
+		Value:    "",
+		Path:     "/api",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
 // --- HTTP Handlers
 
 // Register handles user registration requests.
 // POST /api/auth/register
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := DecodeJSON(r, &req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer r.Body.Close()
 
-	if req.Email == "" || req.Password == "" {
-		RespondWithError(w, http.StatusBadRequest, "Email and password are required")
-		return
-	}
-
-	// map handler to service input
+	// map handler to service input (validation is done by the service)
 	serviceInput := RegisterUserInput{
 		Email:    req.Email,
 		Password: req.Password,
@@ -90,11 +234,23 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 	newUser, err := h.service.RegisterUser(r.Context(), serviceInput)
 	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
 		log.Printf("Registration error for email %s: %v", req.Email, err)
-		if errors.Is(err, ErrUserAlreadyExists) {
-			RespondWithError(w, http.StatusConflict, "User with this email already exists")
-		} else if strings.Contains(err.Error(), "password must be at least") {
-			RespondWithError(w, http.StatusBadRequest, err.Error())
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			RespondWithAPIError(w, http.StatusBadRequest, APIError{
+				Code:   ErrorCodeValidation,
+				Fields: validationErr.Fields,
+			})
+		} else if errors.Is(err, ErrUserAlreadyExists) {
+			RespondWithAPIError(w, http.StatusConflict, APIError{
+				Code:    ErrorCodeEmailTaken,
+				Message: "User with this email already exists",
+			})
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
 		} else {
 			RespondWithError(w, http.StatusInternalServerError, "Failed to register user")
 		}
@@ -108,18 +264,18 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	// for registration, don't log the user in immediately or issue tokens.
 	// the user is expected to log in separately.
 	log.Printf("User registered via handler: %s (ID: %s)", responseUser.Email, responseUser.ID)
-	RespondWithJSON(w, http.StatusCreated, responseUser) // return user info, no tokens
+	// there is no per-id user route, the new account is served by /api/me once logged in
+	RespondCreated(w, "/api/me", responseUser) // return user info, no tokens
 }
 
 // Login handles user login requests.
 // POST /api/auth/login
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := DecodeJSON(r, &req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer r.Body.Close()
 
 	if req.Email == "" || req.Password == "" {
 		RespondWithError(w, http.StatusBadRequest, "Email and password are required")
@@ -133,9 +289,16 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	loginResponse, err := h.service.LoginUser(r.Context(), serviceInput)
 	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
 		log.Printf("Login error for email %s: %v", req.Email, err)
 		if errors.Is(err, ErrInvalidCredentials) {
 			RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
+		} else if errors.Is(err, ErrAccountDeleted) {
+			RespondWithError(w, http.StatusForbidden, "This account has been deleted")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
 		} else {
 			RespondWithError(w, http.StatusInternalServerError, "Failed to log in")
 		}
@@ -146,7 +309,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	// the cookie's secure attribute should be true if served over HTTPS.
 	// for local development on HTTP it may need to be false or the browser will ignore it.
 	// checks like h.cfg.AppEnv == "production" (needs to be added) or similar are good here.
-	secureCookie := h.cfg.AppEnv == "production" // simple check for "are we in a prod env?"
+	secureCookie := h.secureCookies()
 	// another way would be to have an explicit DOMAIN in config.
 
 	http.SetCookie(w, &http.Cookie{
@@ -159,10 +322,12 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 		// Domain: h.cfg.CookieDomain, // should be set if api and frontend are on different subdomains
 	})
+	h.setAccessTokenCookie(w, loginResponse.AccessToken, loginResponse.AccessTokenExpiresAt)
 
 	// prepare response (access token in body, user info)
 	apiResponse := AuthResponse{
 		AccessToken: loginResponse.AccessToken,
+		ExpiresAt:   loginResponse.AccessTokenExpiresAt,
 		User:        loginResponse.User,
 	}
 
@@ -194,32 +359,42 @@ func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	// 2. call service to process refresh token and get new tokens
 	refreshResponse, err := h.service.ProcessRefreshToken(r.Context(), oldRefreshTokenString)
 	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
 		// ProcessRefreshToken returns ErrInvalidToken for most failures (expired, not found, etc.)
 		log.Printf("Failed to refresh token: %v", err)
 		if errors.Is(err, ErrInvalidToken) { // generic error from service for bad refresh tokens
 			RespondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
 		} else {
 			RespondWithError(w, http.StatusInternalServerError, "Could not refresh token")
 		}
 		return
 	}
 
-	// 3. set new refresh token in HttpOnly cookie
-	secureCookie := h.cfg.AppEnv == "production" // same logic as in Login
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refreshToken",
-		Value:    refreshResponse.RefreshToken, // new refresh token
-		Path:     "/api/auth",
-		Expires:  time.Now().Add(h.service.refreshTokenExpiration),
-		HttpOnly: true,
-		Secure:   secureCookie,
-		SameSite: http.SameSiteStrictMode,
-		// Domain: h.cfg.CookieDomain,
-	})
+	// 3. set new refresh token in HttpOnly cookie.
+	// a grace-period reuse gets none: its sibling request already set the successor's cookie
+	if refreshResponse.RefreshToken != "" {
+		secureCookie := h.secureCookies() // same logic as in Login
+		http.SetCookie(w, &http.Cookie{
+			Name:     "refreshToken",
+			Value:    refreshResponse.RefreshToken, // new refresh token
+			Path:     "/api/auth",
+			Expires:  time.Now().Add(h.service.refreshTokenExpiration),
+			HttpOnly: true,
+			Secure:   secureCookie,
+			SameSite: http.SameSiteStrictMode,
+			// Domain: h.cfg.CookieDomain,
+		})
+	}
+	h.setAccessTokenCookie(w, refreshResponse.AccessToken, refreshResponse.AccessTokenExpiresAt)
 
-	// 4. send new access token in the response body
+	// 4. send new access token and its expiry in the response body
 	responsePayload := map[string]string{
 		"accessToken": refreshResponse.AccessToken,
+		"expiresAt":   refreshResponse.AccessTokenExpiresAt.Format(time.RFC3339),
 	}
 	RespondWithJSON(w, http.StatusOK, responsePayload)
 }
@@ -232,7 +407,7 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 
 	// check if the cookie exists, though not strictly necessary
 	// as setting an expired cookie with the same name will clear it.
-	_, err := r.Cookie("refreshToken")
+	cookie, err := r.Cookie("refreshToken")
 	if err != nil {
 		if errors.Is(err, http.ErrNoCookie) {
 			// no cookie to clear, user might already be logged out
@@ -242,9 +417,12 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		}
 		// some other error reading the cookie, though unlikely to be critical for logout.
 		log.Printf("Error reading cookie during logout (non-critical): %v", err)
+	} else if err := h.service.LogoutUser(r.Context(), cookie.Value); err != nil {
+		// still clear the cookie below, the client asked to be logged out
+		log.Printf("Error revoking refresh token during logout: %v", err)
 	}
 
-	secureCookie := h.cfg.DBSslMode != "disable" // Same logic as in Login/Refresh
+	secureCookie := h.secureCookies() // Same logic as in Login/Refresh
 
 	// to delete it, either:
 	//	 1 - Expires can be set to a past time (like epoch time time.Unix(0, 0))
@@ -260,7 +438,252 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 		// Domain: h.cfg.CookieDomain,
 	})
+	h.clearAccessTokenCookie(w)
 
 	log.Println("User logout: refreshToken cookie cleared.")
 	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Successfully logged out"})
 }
+
+// Me returns the authenticated user's info.
+// GET /api/me
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	claims, ok := RequireClaims(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := h.service.GetUser(r.Context(), claims.UserID)
+	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
+		if errors.Is(err, ErrUserNotFound) {
+			// user was deleted after the token was issued
+			RespondWithError(w, http.StatusUnauthorized, "User no longer exists")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			log.Printf("Error fetching current user %s: %v", claims.UserID, err)
+			RespondWithError(w, http.StatusInternalServerError, "Failed to fetch current user")
+		}
+		return
+	}
+	userInfo := ToUserInfoForResponse(u)
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":     "Current user:",
+		"userId":      userInfo.ID,
+		"email":       userInfo.Email,
+		"displayName": userInfo.DisplayName,
+		"createdAt":   userInfo.CreatedAt,
+		"updatedAt":   userInfo.UpdatedAt,
+		"expiresAt":   claims.ExpiresAt.Time.UTC().Format(time.RFC3339),
+	})
+}
+
+// Verify tells the client whether its access token is still valid, e.g. on app load.
+// it must be mounted behind Authenticate, which answers the 401 for invalid tokens, and it
+// never touches the database: a valid signature and expiry is all that's checked.
+// GET /api/auth/verify
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	claims, ok := RequireClaims(w, r)
+	if !ok {
+		return
+	}
+
+	response := VerifyResponse{
+		Valid:  true,
+		UserID: claims.UserID,
+		Email:  claims.Email,
+		Role:   claims.Role,
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Time.UTC()
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// ChangeEmail starts changing the authenticated user's email.
+// PATCH /api/me/email
+//
+// the change is gated behind a confirmation token sent to the new address rather than
+// switching immediately and flagging the account unverified: that way a typo'd or
+// unreachable address can't lock the user out, and the current email keeps working for
+// login until the new one is proven. confirming the token applies the change and marks
+// the new address verified, since receiving the token is the verification.
+func (h *Handler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := RequireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := DecodeJSON(r, &req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.RequestEmailChange(r.Context(), userID, req.Email); err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
+		log.Printf("Email change request error for user %s: %v", userID, err)
+		var validationErr *ValidationError
+		var cooldownErr *EmailChangeCooldownError
+		if errors.As(err, &validationErr) {
+			RespondWithAPIError(w, http.StatusBadRequest, APIError{
+				Code:   ErrorCodeValidation,
+				Fields: validationErr.Fields,
+			})
+		} else if errors.As(err, &cooldownErr) {
+			RespondRateLimited(w, "Email was changed recently, try again later", 1, cooldownErr.Cooldown, cooldownErr.RetryAfter)
+		} else if errors.Is(err, ErrEmailUnchanged) {
+			RespondWithError(w, http.StatusBadRequest, "New email is the same as the current one")
+		} else if errors.Is(err, ErrUserAlreadyExists) {
+			RespondWithError(w, http.StatusConflict, "User with this email already exists")
+		} else if errors.Is(err, ErrUserNotFound) {
+			RespondWithError(w, http.StatusUnauthorized, "User no longer exists")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to request email change")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "Confirmation sent to the new email address. Your current email stays active until it is confirmed.",
+	})
+}
+
+// ChangeDisplayName sets the authenticated user's public display name.
+// PUT /api/me/display-name
+func (h *Handler) ChangeDisplayName(w http.ResponseWriter, r *http.Request) {
+	userID, ok := RequireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangeDisplayNameRequest
+	if err := DecodeJSON(r, &req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := h.service.ChangeDisplayName(r.Context(), userID, req.DisplayName)
+	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			RespondWithAPIError(w, http.StatusBadRequest, APIError{
+				Code:   ErrorCodeValidation,
+				Fields: validationErr.Fields,
+			})
+		} else if errors.Is(err, ErrDisplayNameTaken) {
+			RespondWithAPIError(w, http.StatusConflict, APIError{
+				Code:    ErrorCodeNameTaken,
+				Message: "This display name is already taken",
+			})
+		} else if errors.Is(err, ErrUserNotFound) {
+			RespondWithError(w, http.StatusUnauthorized, "User no longer exists")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			log.Printf("Display name change error for user %s: %v", userID, err)
+			RespondWithError(w, http.StatusInternalServerError, "Failed to change display name")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, ToUserInfoForResponse(u))
+}
+
+// ConfirmEmailChange applies a pending email change using the token sent to the new address.
+// it is public: possession of the token is what authorizes the change.
+// POST /api/auth/email/confirm
+func (h *Handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmEmailChangeRequest
+	if err := DecodeJSON(r, &req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := h.service.ConfirmEmailChange(r.Context(), req.Token)
+	if err != nil {
+		if ClientCanceled(r, err) {
+			return
+		}
+		log.Printf("Email change confirmation error: %v", err)
+		var cooldownErr *EmailChangeCooldownError
+		if errors.Is(err, ErrInvalidToken) {
+			RespondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation token")
+		} else if errors.As(err, &cooldownErr) {
+			RespondRateLimited(w, "Email was changed recently, try again later", 1, cooldownErr.Cooldown, cooldownErr.RetryAfter)
+		} else if errors.Is(err, ErrUserAlreadyExists) {
+			RespondWithError(w, http.StatusConflict, "User with this email already exists")
+		} else if errors.Is(err, ErrUserNotFound) {
+			RespondWithError(w, http.StatusNotFound, "User no longer exists")
+		} else if database.IsQueryTimeout(err) {
+			RespondWithError(w, http.StatusGatewayTimeout, "Database request timed out")
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to confirm email change")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, ToUserInfoForResponse(u))
+}
+
+// Introspect reports whether an access token is active, modeled on RFC 7662.
+// it is meant for internal services and is authenticated with the introspection client
+// credentials (HTTP basic auth) instead of a user token. the token is sent form-encoded
+// as `token`, per the RFC.
+// POST /api/auth/introspect
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.IntrospectionClientID == "" || h.cfg.IntrospectionClientSecret == "" {
+		RespondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	// compare both even if the first fails, so timing doesn't reveal which one was wrong
+	idMatch := subtle.ConstantTimeCompare([]byte(clientID), []byte(h.cfg.IntrospectionClientID))
+	secretMatch := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(h.cfg.IntrospectionClientSecret))
+	if !ok || idMatch&secretMatch != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+		RespondWithError(w, http.StatusUnauthorized, "Invalid client credentials")
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		RespondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	claims, err := h.service.ValidateToken(token)
+	if err != nil {
+		// expired, malformed, bad signature...: all just inactive to the caller
+		RespondWithJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	response := IntrospectionResponse{
+		Active:    true,
+		TokenType: "access_token",
+		Sub:       claims.Subject,
+		Iss:       claims.Issuer,
+		UserID:    claims.UserID,
+		Email:     claims.Email,
+		Role:      claims.Role,
+	}
+	if claims.ExpiresAt != nil {
+		response.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.Iat = claims.IssuedAt.Unix()
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
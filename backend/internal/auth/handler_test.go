@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"backend/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuthHandlersRejectUnknownFields(t *testing.T) {
+	// the body is rejected while decoding, before the service is reached
+	h := &Handler{cfg: &config.Config{}}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    string
+	}{
+		{
+			name:    "register",
+			handler: h.Register,
+			body:    `{"email":"user@example.com","passwrod":"secret123"}`,
+			want:    "Request body contains unknown field 'passwrod'",
+		},
+		{
+			name:    "login",
+			handler: h.Login,
+			body:    `{"email":"user@example.com","password":"secret123","remember":true}`,
+			want:    "Request body contains unknown field 'remember'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type = %q, want application/json", ct)
+			}
+			var envelope map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("body %q is not the error envelope: %v", rec.Body.String(), err)
+			}
+			if envelope["error"] != tt.want {
+				t.Fatalf("error = %q, want %q", envelope["error"], tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectInactiveToken(t *testing.T) {
+	h := &Handler{
+		service: newTestAuthService(),
+		cfg:     &config.Config{IntrospectionClientID: "client", IntrospectionClientSecret: "secret"},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url.Values{"token": {"not-a-jwt"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client", "secret")
+	rec := httptest.NewRecorder()
+	h.Introspect(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// RFC 7662: an inactive token reveals nothing else
+	if body := rec.Body.String(); body != `{"active":false}` {
+		t.Fatalf("body = %s, want {\"active\":false}", body)
+	}
+}
@@ -1,10 +1,17 @@
 package auth
 
 import (
+	"backend/internal/config"
+	"backend/internal/logging"
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 // ContextKey is a custom type for context keys to avoid collisions.
@@ -18,51 +25,170 @@ const (
 // Middleware is the middleware which provides authentication.
 type Middleware struct {
 	service *AuthService
+	cfg     *config.Config
 }
 
 // NewMiddleware creates a new auth middleware instance.
-func NewMiddleware(service *AuthService) *Middleware {
-	return &Middleware{service: service}
+func NewMiddleware(service *AuthService, cfg *config.Config) *Middleware {
+	if cfg == nil {
+		log.Fatal("Auth Middleware: Config cannot be nil")
+	}
+	return &Middleware{service: service, cfg: cfg}
+}
+
+// cookieToken returns the access token cookie's value, or "" when cookie delivery is
+// disabled or the cookie is absent.
+func (m *Middleware) cookieToken(r *http.Request) string {
+	if !m.cfg.AccessTokenInCookie {
+		return ""
+	}
+	cookie, err := r.Cookie(AccessTokenCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// error codes of the WWW-Authenticate Bearer challenge (RFC 6750 section 3.1).
+const (
+	bearerErrorInvalidRequest = "invalid_request"
+	bearerErrorInvalidToken   = "invalid_token"
+)
+
+// respondUnauthorized writes a 401 with both the JSON error body and a
+// `WWW-Authenticate: Bearer` challenge, so generic HTTP clients understand it too.
+// errorCode and description are optional and omitted from the challenge when empty.
+func respondUnauthorized(w http.ResponseWriter, errorCode, description, message string) {
+	challenge := `Bearer realm="PaperTrading"`
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s"`, errorCode)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(`, error_description="%s"`, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	RespondWithError(w, http.StatusUnauthorized, message)
 }
 
-// Authenticate is a go-chi middleware that checks for a valid JWT in the `Authorization` header.
+// Authenticate is a go-chi middleware that checks for a valid JWT in the `Authorization` header,
+// or, when ACCESS_TOKEN_IN_COOKIE is enabled and the header is absent, in the access token cookie.
 // if valid, it stores the claims in the request context.
 func (m *Middleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tokenString string
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			RespondWithError(w, http.StatusUnauthorized, "Authorization header required")
-			return
-		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			RespondWithError(w, http.StatusUnauthorized, "Authorization header format must be Bearer {token}")
+		if authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				respondUnauthorized(w, bearerErrorInvalidRequest, "malformed Authorization header", "Authorization header format must be Bearer {token}")
+				return
+			}
+			tokenString = parts[1]
+		} else if tokenString = m.cookieToken(r); tokenString == "" {
+			// no error code when credentials are simply missing (RFC 6750 section 3.1)
+			respondUnauthorized(w, "", "", "Authorization header required")
 			return
 		}
 
-		tokenString := parts[1]
 		claims, err := m.service.ValidateToken(tokenString) // ValidateToken already handles "Bearer " prefix if it was there
 		if err != nil {
 			// ValidateToken returns specific errors like ErrTokenExpired, ErrInvalidToken
 			// this means the response can be tailored based on the error type
 			if errors.Is(err, ErrTokenExpired) {
-				RespondWithError(w, http.StatusUnauthorized, "Token has expired")
+				respondUnauthorized(w, bearerErrorInvalidToken, "the access token expired", "Token has expired")
+			} else if errors.Is(err, ErrTokenNotValidYet) {
+				// usually the client's clock is ahead of ours by more than the leeway
+				respondUnauthorized(w, bearerErrorInvalidToken, "the access token is not valid yet", "Token is not valid yet, check that your device clock is correct")
 			} else {
-				RespondWithError(w, http.StatusUnauthorized, "Invalid or malformed token")
+				respondUnauthorized(w, bearerErrorInvalidToken, "", "Invalid or malformed token")
 			}
 			return
 		}
 
 		// token is valid, store claims in context
+		logging.SetUserID(r.Context(), claims.UserID)
 		ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// OptionalAuthenticate is a go-chi middleware for routes that work with or without a user.
+// it stores the claims in the request context when a valid Bearer token is sent, and otherwise
+// (no header, bad format, invalid token) lets the request through without claims. it never
+// returns 401: handlers branch on GetUserClaims instead.
+func (m *Middleware) OptionalAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := m.cookieToken(r)
+		parts := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+			tokenString = parts[1] // the header wins over the cookie, like in Authenticate
+		}
+		if tokenString != "" {
+			if claims, err := m.service.ValidateToken(tokenString); err == nil {
+				logging.SetUserID(r.Context(), claims.UserID)
+				r = r.WithContext(context.WithValue(r.Context(), UserClaimsKey, claims))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole is a go-chi middleware that only lets through users whose role is one of roles.
+// it must be mounted after Authenticate, since it reads the claims from the request context.
+// roles are read from the access token, so a role change takes effect once a new token is issued.
+func (m *Middleware) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := RequireClaims(w, r)
+			if !ok {
+				return
+			}
+
+			if !slices.Contains(roles, claims.Role) {
+				RespondWithError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // GetUserClaims retrieves user claims from the request context.
 // this is a helper function for protected handlers.
 func GetUserClaims(ctx context.Context) (*JWTCustomClaims, bool) {
 	claims, ok := ctx.Value(UserClaimsKey).(*JWTCustomClaims)
 	return claims, ok
 }
+
+// MustUserID returns the authenticated user's ID from the request context.
+// ok is false when no claims are present, i.e. the route is not behind Authenticate.
+func MustUserID(ctx context.Context) (uuid.UUID, bool) {
+	claims, ok := GetUserClaims(ctx)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return claims.UserID, true
+}
+
+// RequireClaims returns the authenticated user's claims, or writes a 401 and returns false.
+// protected handlers can just `return` when ok is false.
+func RequireClaims(w http.ResponseWriter, r *http.Request) (*JWTCustomClaims, bool) {
+	claims, ok := GetUserClaims(r.Context())
+	if !ok {
+		// this should ideally not happen if middleware is working correctly
+		// and has already validated, but as a safeguard:
+		RespondWithError(w, http.StatusUnauthorized, "Unable to retrieve user claims")
+		return nil, false
+	}
+	return claims, true
+}
+
+// RequireUserID is RequireClaims for handlers that only need the user's ID.
+func RequireUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	claims, ok := RequireClaims(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return claims.UserID, true
+}
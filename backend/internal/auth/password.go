@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// --- Password Hashing
+
+// password hashing algorithms, stored in users.password_algo as "<algo>:<cost>".
+const (
+	// PasswordAlgoBcrypt is bcrypt over the raw password. only older hashes use it,
+	// they are upgraded to PasswordAlgoBcryptSHA256 on login.
+	PasswordAlgoBcrypt = "bcrypt"
+	// PasswordAlgoBcryptSHA256 is bcrypt over the base64 encoded SHA-256 of the password,
+	// which lifts bcrypt's 72 byte limit. base64 also keeps NUL bytes out of the bcrypt input.
+	PasswordAlgoBcryptSHA256 = "bcrypt-sha256"
+)
+
+// maxBcryptPasswordBytes is where bcrypt stops reading its input.
+const maxBcryptPasswordBytes = 72
+
+// prehashPassword is the bcrypt input of PasswordAlgoBcryptSHA256.
+func prehashPassword(password string) []byte {
+	digest := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+func formatPasswordAlgo(algo string, cost int) string {
+	return algo + ":" + strconv.Itoa(cost)
+}
+
+// parsePasswordAlgo splits a password_algo value into its algorithm and cost.
+func parsePasswordAlgo(value string) (string, int, error) {
+	algo, costStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed password algo %q", value)
+	}
+	cost, err := strconv.Atoi(costStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed password algo cost %q: %w", value, err)
+	}
+	return algo, cost, nil
+}
+
+// HashPassword hashes the pre-hashed password with bcrypt at the given cost (PasswordAlgoBcryptSHA256).
+// it also returns the password_algo value to store alongside the hash.
+func HashPassword(password string, cost int) (string, string, error) {
+	bytes, err := bcrypt.GenerateFromPassword(prehashPassword(password), cost)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(bytes), formatPasswordAlgo(PasswordAlgoBcryptSHA256, cost), nil
+}
+
+// CheckPasswordHash compares a plain text password with a stored hash,
+// using the algorithm recorded in passwordAlgo. unknown algorithms never match.
+// legacy bcrypt passwords over 72 bytes never match either: bcrypt would only compare their
+// first bytes, so any long password sharing a stored password's prefix would be accepted.
+func CheckPasswordHash(password, hash, passwordAlgo string) bool {
+	algo, _, err := parsePasswordAlgo(passwordAlgo)
+	if err != nil {
+		return false
+	}
+	switch algo {
+	case PasswordAlgoBcrypt:
+		if len(password) > maxBcryptPasswordBytes {
+			return false
+		}
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case PasswordAlgoBcryptSHA256:
+		return bcrypt.CompareHashAndPassword([]byte(hash), prehashPassword(password)) == nil
+	default:
+		return false
+	}
+}
+
+// passwordNeedsRehash reports whether a stored hash is outdated, i.e. it was not produced
+// by PasswordAlgoBcryptSHA256 at cost. the cost is read from the hash itself rather than trusting
+// password_algo, since rows predating the column all got its default.
+func passwordNeedsRehash(hash, passwordAlgo string, cost int) bool {
+	algo, _, err := parsePasswordAlgo(passwordAlgo)
+	if err != nil || algo != PasswordAlgoBcryptSHA256 {
+		return true
+	}
+	hashCost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return hashCost < cost
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, algo, err := HashPassword("correct horse 1", bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if want := formatPasswordAlgo(PasswordAlgoBcryptSHA256, bcrypt.MinCost); algo != want {
+		t.Fatalf("HashPassword() algo = %q, want %q", algo, want)
+	}
+	if !CheckPasswordHash("correct horse 1", hash, algo) {
+		t.Fatal("CheckPasswordHash() rejected the hashed password")
+	}
+	if CheckPasswordHash("correct horse 2", hash, algo) {
+		t.Fatal("CheckPasswordHash() accepted a wrong password")
+	}
+}
+
+func TestLongPasswordsSharingAPrefixDontCollide(t *testing.T) {
+	prefix := strings.Repeat("a", maxBcryptPasswordBytes)
+	first, second := prefix+"first", prefix+"second"
+
+	hash, algo, err := HashPassword(first, bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if !CheckPasswordHash(first, hash, algo) {
+		t.Fatal("CheckPasswordHash() rejected a long password")
+	}
+	if CheckPasswordHash(second, hash, algo) {
+		t.Fatal("CheckPasswordHash() accepted another password sharing the 72 byte prefix")
+	}
+}
+
+func TestLegacyBcryptHashStillValidates(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("old password 1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+	}
+	algo := formatPasswordAlgo(PasswordAlgoBcrypt, bcrypt.MinCost)
+
+	if !CheckPasswordHash("old password 1", string(legacy), algo) {
+		t.Fatal("CheckPasswordHash() rejected a legacy bcrypt hash")
+	}
+	if CheckPasswordHash("old password 2", string(legacy), algo) {
+		t.Fatal("CheckPasswordHash() accepted a wrong password for a legacy hash")
+	}
+	// the new scheme must not be confused with the legacy one
+	if CheckPasswordHash("old password 1", string(legacy), formatPasswordAlgo(PasswordAlgoBcryptSHA256, bcrypt.MinCost)) {
+		t.Fatal("CheckPasswordHash() accepted a legacy hash under the pre-hashed algo")
+	}
+}
+
+func TestLegacyBcryptRejectsPasswordsOverTheLimit(t *testing.T) {
+	stored := strings.Repeat("b", maxBcryptPasswordBytes)
+	legacy, err := bcrypt.GenerateFromPassword([]byte(stored), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+	}
+	algo := formatPasswordAlgo(PasswordAlgoBcrypt, bcrypt.MinCost)
+
+	// bcrypt alone would accept it, only the first 72 bytes are compared
+	if CheckPasswordHash(stored+"extra", string(legacy), algo) {
+		t.Fatal("CheckPasswordHash() accepted a longer password sharing the stored one's prefix")
+	}
+}
+
+func TestCheckPasswordHashUnknownAlgo(t *testing.T) {
+	hash, _, err := HashPassword("some password 1", bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	for _, algo := range []string{"", "argon2id:1", "bcrypt-sha256"} {
+		if CheckPasswordHash("some password 1", hash, algo) {
+			t.Errorf("CheckPasswordHash() accepted password_algo %q", algo)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	current, currentAlgo, err := HashPassword("some password 1", bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("some password 1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		algo string
+		cost int
+		want bool
+	}{
+		{"current algo and cost", current, currentAlgo, bcrypt.MinCost, false},
+		{"current algo, cost raised since", current, currentAlgo, bcrypt.MinCost + 1, true},
+		{"current algo, cost lowered since", current, currentAlgo, bcrypt.MinCost - 1, false},
+		{"legacy bcrypt", string(legacy), formatPasswordAlgo(PasswordAlgoBcrypt, bcrypt.MinCost), bcrypt.MinCost, true},
+		// the cost comes from the hash, a stale password_algo cost must not hide a weak hash
+		{"algo claims a higher cost than the hash", current, formatPasswordAlgo(PasswordAlgoBcryptSHA256, 12), 12, true},
+		{"malformed algo", current, "bcrypt-sha256", bcrypt.MinCost, true},
+		{"unparsable hash", "not a bcrypt hash", currentAlgo, bcrypt.MinCost, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := passwordNeedsRehash(tt.hash, tt.algo, tt.cost); got != tt.want {
+				t.Errorf("passwordNeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
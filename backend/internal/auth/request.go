@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// --- Helper Functions for HTTP requests
+
+// DecodeJSON decodes the request body into dst, rejecting unknown fields.
+// the returned error message is safe to send to the client as-is with a 400.
+func DecodeJSON(r *http.Request, dst any) error {
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("Request body must not be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("Request body contains malformed JSON")
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("Request body contains malformed JSON (at position %d)", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Errorf("Request body must be %s", describeJSONType(typeErr.Type))
+		}
+		return fmt.Errorf("field '%s' must be %s", typeErr.Field, describeJSONType(typeErr.Type))
+	case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+		// encoding/json has no typed error for this, the message is `json: unknown field "name"`
+		field := strings.Trim(strings.TrimPrefix(err.Error(), unknownFieldPrefix), `"`)
+		return fmt.Errorf("Request body contains unknown field '%s'", field)
+	default:
+		return errors.New("Invalid request payload")
+	}
+}
+
+// unknownFieldPrefix is how encoding/json starts the error for fields rejected by DisallowUnknownFields.
+const unknownFieldPrefix = "json: unknown field "
+
+// describeJSONType names a Go type by the JSON value it expects, e.g. "a string".
+func describeJSONType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return "a valid " + t.String()
+	}
+}
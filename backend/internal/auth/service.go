@@ -1,19 +1,22 @@
 package auth
 
 import (
+	"backend/internal/audit"
 	"backend/internal/config"
+	"backend/internal/email"
 	"backend/internal/user"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 )
@@ -24,92 +27,136 @@ var (
 	ErrTokenExpired       = errors.New("token has expired")
 	ErrTokenNotValidYet   = errors.New("token not valid yet")
 	ErrInvalidToken       = errors.New("invalid token")
+	ErrAccountDeleted     = errors.New("account has been deleted")
+	ErrEmailUnchanged     = errors.New("new email is the same as the current one")
 )
 
+// registration picks a random display name, retried on the (unlikely) collision with a taken one.
+const (
+	defaultDisplayNamePrefix   = "trader_"
+	defaultDisplayNameAttempts = 3
+)
+
+// emailChangeTokenExpiration is how long a new address has to be confirmed.
+const emailChangeTokenExpiration = 24 * time.Hour
+
+// EmailChangeCooldownError is returned when an email change is requested or confirmed too soon after the last one.
+type EmailChangeCooldownError struct {
+	Cooldown   time.Duration
+	RetryAfter time.Duration
+}
+
+func (e *EmailChangeCooldownError) Error() string {
+	return fmt.Sprintf("email was changed recently, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
 // JWTCustomClaims defines the custom claims for our JWT.
 // It embeds jwt.RegisteredClaims and adds our own.
 type JWTCustomClaims struct {
 	UserID uuid.UUID `json:"uid"`
 	Email  string    `json:"email"`
-	// TODO: add other claims like roles, permissions, etc.
+	Role   string    `json:"role"`
+	// TODO: add other claims like permissions, etc.
 	jwt.RegisteredClaims
 }
 
 // AuthService provides authentication related services.
 type AuthService struct {
-	db *pgxpool.Pool
-	ts *TokenStore
-	us *UserStore
+	db    *pgxpool.Pool
+	ts    *TokenStore
+	us    *UserStore
+	audit *audit.Store
+	mail  email.EmailSender
 
 	// individual jwt settings
 	jwtSecret              string
+	jwtIssuer              string
+	jwtAudience            string
+	jwtLeeway              time.Duration
+	jwtAllowedAlgs         []string
 	jwtExpiration          time.Duration
 	refreshTokenExpiration time.Duration
+
+	bcryptCost     int
+	passwordPolicy passwordPolicy
+	appBaseURL     string
+
+	emailChangeCooldown time.Duration
 }
 
-func NewAuthService(db *pgxpool.Pool, us *UserStore, ts *TokenStore, cfg *config.Config) *AuthService {
+func NewAuthService(db *pgxpool.Pool, us *UserStore, ts *TokenStore, as *audit.Store, mail email.EmailSender, cfg *config.Config) *AuthService {
 	if cfg == nil {
 		log.Fatal("AuthService: config cannot be nil")
 	}
+	if len(cfg.JWTSecret) < config.MinJWTSecretLength {
+		// config.Load already refuses this, the check guards callers building a Config by hand
+		log.Fatal("AuthService: JWT secret is missing or too short")
+	}
 	if db == nil {
 		log.Fatal("AuthService: database pool cannot be nil")
 	}
+	if as == nil {
+		log.Fatal("AuthService: audit store cannot be nil")
+	}
+	if mail == nil {
+		log.Fatal("AuthService: email sender cannot be nil")
+	}
 	return &AuthService{
-		db: db,
-		us: us,
-		ts: ts,
+		db:    db,
+		us:    us,
+		ts:    ts,
+		audit: as,
+		mail:  mail,
 
 		jwtSecret:              cfg.JWTSecret,
+		jwtIssuer:              cfg.JWTIssuer,
+		jwtAudience:            cfg.JWTAudience,
+		jwtLeeway:              cfg.JWTLeeway,
+		jwtAllowedAlgs:         cfg.JWTAllowedAlgs,
 		jwtExpiration:          cfg.JWTExpiration,
 		refreshTokenExpiration: cfg.RefreshTokenExpiration,
-	}
-}
 
-// --- Password Hashing
+		bcryptCost:     cfg.BcryptCost,
+		passwordPolicy: passwordPolicy{minLength: cfg.PasswordMinLength, maxLength: cfg.PasswordMaxLength},
+		appBaseURL:     cfg.AppBaseURL,
 
-// HashPassword generates a bcrypt hash of the password.
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
+		emailChangeCooldown: cfg.EmailChangeCooldown,
 	}
-	return string(bytes), nil
-}
-
-// CheckPasswordHash compares a plain text password with a stored bcrypt hash.
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
 }
 
 // --- Token Generation
 
 // GenerateAccessToken creates a new JWT access token for a user.
-func (s *AuthService) GenerateAccessToken(u *user.User) (string, error) {
+// it also returns the token's expiry (its `exp` claim), so clients can refresh ahead of time.
+func (s *AuthService) GenerateAccessToken(u *user.User) (string, time.Time, error) {
 	if u == nil {
-		return "", errors.New("user cannot be nil for token generation")
+		return "", time.Time{}, errors.New("user cannot be nil for token generation")
 	}
 
 	claims := &JWTCustomClaims{
 		UserID: u.ID,
 		Email:  u.Email,
+		Role:   u.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.jwtExpiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "PaperTradingApp", // identifier for our backend
-			Subject:   u.ID.String(),     // subject of the token (user that's related to it)
+			Issuer:    s.jwtIssuer,   // identifier for our backend
+			Subject:   u.ID.String(), // subject of the token (user that's related to it)
 			//ID: // TODO: JWT ID, can be used for tracking/revocation
 		},
 	}
+	if s.jwtAudience != "" {
+		claims.Audience = jwt.ClaimStrings{s.jwtAudience}
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := token.SignedString([]byte(s.jwtSecret))
 	if err != nil {
 		log.Printf("Error signing access token for user %s: %v", u.Email, err)
-		return "", fmt.Errorf("could not sign access token: %w", err)
+		return "", time.Time{}, fmt.Errorf("could not sign access token: %w", err)
 	}
-	return signedToken, nil
+	return signedToken, claims.ExpiresAt.Time.UTC(), nil
 }
 
 // GenerateRefreshToken creates a new refresh token.
@@ -149,13 +196,30 @@ func (s *AuthService) ValidateToken(tokenString string) (*JWTCustomClaims, error
 	// remove "Bearer " prefix if present
 	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
+	// tokens from another issuer/audience sharing the key fail here and end up as ErrInvalidToken.
+	// the leeway keeps slightly skewed clocks from tripping the exp/nbf checks.
+	// an "alg" outside the allowlist is rejected before the key is even looked at.
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(s.jwtAllowedAlgs),
+		jwt.WithIssuer(s.jwtIssuer),
+		jwt.WithLeeway(s.jwtLeeway),
+	}
+	if s.jwtAudience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(s.jwtAudience))
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &JWTCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// validate the used alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// validate the used alg is what you expect. WithValidMethods already enforces the allowlist,
+		// this is the second line of defense: the secret is only ever an HMAC key.
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || !slices.Contains(s.jwtAllowedAlgs, token.Method.Alg()) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+		// an empty HMAC key would make any token signed with an empty key verify
+		if len(s.jwtSecret) == 0 {
+			return nil, errors.New("no JWT secret configured")
+		}
 		return []byte(s.jwtSecret), nil
-	})
+	}, parserOptions...)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -169,11 +233,19 @@ func (s *AuthService) ValidateToken(tokenString string) (*JWTCustomClaims, error
 		return nil, ErrInvalidToken
 	}
 
-	if claims, ok := token.Claims.(*JWTCustomClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*JWTCustomClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// both are set to the user's id at issue, a token where they disagree was not minted by us
+	subject, err := uuid.Parse(claims.Subject)
+	if err != nil || subject == uuid.Nil || subject != claims.UserID {
+		log.Printf("Rejected token with mismatching subject %q and uid %s", claims.Subject, claims.UserID)
+		return nil, ErrInvalidToken
 	}
 
-	return nil, ErrInvalidToken
+	return claims, nil
 }
 
 func generateOpaqueTokenString() (string, error) {
@@ -201,36 +273,36 @@ type RegisterUserInput struct {
 
 // RegisterUser handles new user registration.
 func (s *AuthService) RegisterUser(ctx context.Context, input RegisterUserInput) (*user.User, error) {
-	// 1. basic input validation
-	if input.Email == "" || input.Password == "" {
-		return nil, errors.New("email and password are required")
-	}
-	// TODO: add robust email validation and password complexity rules
-	if len(input.Password) < 8 { // Example: minimum password length
-		return nil, errors.New("password must be at least 8 characters long")
-	}
-
-	// 2. check if user already exists
-	existingUser, err := s.us.FindUserByEmailInDB(ctx, input.Email)
-	if err != nil && !errors.Is(err, ErrUserNotFound) {
-		log.Printf("Error checking for existing user during registration: %v", err)
-		return nil, fmt.Errorf("could not verify user existence: %w", err)
-	}
-	if existingUser != nil {
-		return nil, ErrUserAlreadyExists
+	// 1. input validation, every invalid field is reported at once
+	if err := validateRegisterInput(input, s.passwordPolicy); err != nil {
+		return nil, err
 	}
 
-	// 3. hash password
-	hashedPassword, err := HashPassword(input.Password)
+	// 2. hash password
+	// there is deliberately no "does the email exist" lookup first: it raced with the insert and
+	// answered faster for taken emails. the unique constraint alone decides, after the hashing.
+	hashedPassword, passwordAlgo, err := HashPassword(input.Password, s.bcryptCost)
 	if err != nil {
 		log.Printf("Error hashing password during registration for email %s: %v", input.Email, err)
 		return nil, fmt.Errorf("could not process password: %w", err)
 	}
 
-	// 4. create user in the database
-	newUser, err := s.us.CreateUserInDB(ctx, input.Email, hashedPassword)
-	if err != nil {
-		return nil, fmt.Errorf("could not register user: %w", err)
+	// 3. create user in the database, a taken email surfaces as ErrUserAlreadyExists.
+	// the display name is a random handle rather than derived from the email, which it must never reveal
+	var newUser *user.User
+	for attempt := 1; ; attempt++ {
+		displayName, err := generateDisplayName()
+		if err != nil {
+			return nil, fmt.Errorf("could not register user: %w", err)
+		}
+		newUser, err = s.us.CreateUserInDB(ctx, input.Email, hashedPassword, passwordAlgo, displayName)
+		if errors.Is(err, ErrDisplayNameTaken) && attempt < defaultDisplayNameAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not register user: %w", err)
+		}
+		break
 	}
 
 	log.Printf("User registered successfully: %s (ID: %s)", newUser.Email, newUser.ID)
@@ -249,9 +321,10 @@ type LoginUserInput struct {
 
 // LoginUserResponse defines the successful login response.
 type LoginUserResponse struct {
-	AccessToken  string
-	RefreshToken string // this is sent in an HttpOnly cookie from the handler
-	User         UserInfoForResponse
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+	RefreshToken         string // this is sent in an HttpOnly cookie from the handler
+	User                 UserInfoForResponse
 }
 
 // LoginUser handles user login.
@@ -261,10 +334,11 @@ func (s *AuthService) LoginUser(ctx context.Context, input LoginUserInput) (*Log
 		return nil, errors.New("email and password are required for login")
 	}
 
-	// 2. find user by email
-	u, err := s.us.FindUserByEmailInDB(ctx, input.Email)
+	// 2. find user by email (soft-deleted users included, they get a distinct error below)
+	u, err := s.us.FindUserByEmailIncludingDeletedInDB(ctx, input.Email)
 	if err != nil {
 		if errors.Is(err, ErrUserNotFound) {
+			s.audit.Record(ctx, uuid.Nil, audit.ActionLoginFailure, map[string]any{"email": input.Email, "reason": "unknown_email"})
 			return nil, ErrInvalidCredentials // Generic error for security
 		}
 		log.Printf("Error finding user during login for email %s: %v", input.Email, err)
@@ -272,12 +346,25 @@ func (s *AuthService) LoginUser(ctx context.Context, input LoginUserInput) (*Log
 	}
 
 	// 3. check password
-	if !CheckPasswordHash(input.Password, u.PasswordHash) {
+	if !CheckPasswordHash(input.Password, u.PasswordHash, u.PasswordAlgo) {
+		s.audit.Record(ctx, u.ID, audit.ActionLoginFailure, map[string]any{"email": input.Email, "reason": "invalid_password"})
 		return nil, ErrInvalidCredentials // generic error for security
 	}
 
+	// only reveal the deleted state once the password has been verified
+	if u.IsDeleted() {
+		s.audit.Record(ctx, u.ID, audit.ActionLoginFailure, map[string]any{"email": input.Email, "reason": "account_deleted"})
+		return nil, ErrAccountDeleted
+	}
+
+	// the password is known here, which is the only chance to move outdated hashes to the current algorithm and cost.
+	// hashing at a higher cost is slow on purpose, so it runs in the background instead of delaying the login
+	if passwordNeedsRehash(u.PasswordHash, u.PasswordAlgo, s.bcryptCost) {
+		go s.rehashPassword(context.WithoutCancel(ctx), u.ID, input.Password)
+	}
+
 	// 4. generate tokens
-	accessToken, err := s.GenerateAccessToken(u)
+	accessToken, accessTokenExpiresAt, err := s.GenerateAccessToken(u)
 	if err != nil {
 		return nil, fmt.Errorf("could not generate access token: %w", err)
 	}
@@ -294,20 +381,41 @@ func (s *AuthService) LoginUser(ctx context.Context, input LoginUserInput) (*Log
 	}
 
 	log.Printf("User logged in successfully: %s (ID: %s)", u.Email, u.ID)
+	s.audit.Record(ctx, u.ID, audit.ActionLoginSuccess, nil)
 
 	// important: the user.User struct has PasswordHash tagged with `json:"-"`.
 	// this means that when this LoginUserResponse is marshalled to json by the handler,
 	// the password hash nor a related field will not be included.
 	return &LoginUserResponse{
-		AccessToken:  accessToken,
-		RefreshToken: opaqueRefreshToken,
-		User:         ToUserInfoForResponse(u),
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: accessTokenExpiresAt,
+		RefreshToken:         opaqueRefreshToken,
+		User:                 ToUserInfoForResponse(u),
 	}, nil
 }
 
+// rehashPassword replaces the user's stored hash with one of the current algorithm at the configured cost.
+// failures are only logged: the old hash still works, so the login never fails because of it.
+func (s *AuthService) rehashPassword(ctx context.Context, userID uuid.UUID, password string) {
+	hash, algo, err := HashPassword(password, s.bcryptCost)
+	if err != nil {
+		log.Printf("Error rehashing password for user %s: %v", userID, err)
+		return
+	}
+	if err := s.us.UpdatePasswordHash(ctx, userID, hash, algo); err != nil {
+		return // already logged by the store
+	}
+	log.Printf("Password hash of user %s upgraded to %s", userID, algo)
+}
+
 type UserInfoForResponse struct {
-	ID    uuid.UUID `json:"id"`
-	Email string    `json:"email"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"emailVerified"`
+	DisplayName   string    `json:"displayName"`
+	CreatedAt     time.Time `json:"createdAt"` // always UTC, serialized as RFC3339
+	UpdatedAt     time.Time `json:"updatedAt"` // always UTC, serialized as RFC3339
 }
 
 func ToUserInfoForResponse(u *user.User) UserInfoForResponse {
@@ -315,15 +423,26 @@ func ToUserInfoForResponse(u *user.User) UserInfoForResponse {
 		return UserInfoForResponse{} // Or handle as an error/panic depending on context
 	}
 	return UserInfoForResponse{
-		ID:    u.ID,
-		Email: u.Email,
+		ID:            u.ID,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		CreatedAt:     u.CreatedAt.UTC().Truncate(time.Second), // drop sub-second precision so it marshals as plain RFC3339
+		UpdatedAt:     u.UpdatedAt.UTC().Truncate(time.Second),
 	}
 }
 
+// GetUser returns the non-deleted user with the given ID.
+func (s *AuthService) GetUser(ctx context.Context, userID uuid.UUID) (*user.User, error) {
+	return s.us.FindUserByIDInDB(ctx, userID)
+}
+
 // RefreshTokenResponse defines the response for a successful token refresh.
 type RefreshTokenResponse struct {
-	AccessToken  string
-	RefreshToken string
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+	RefreshToken         string // empty for a grace-period reuse, the client keeps its current cookie
 }
 
 // ProcessRefreshToken validates an existing refresh token and issues new tokens.
@@ -335,32 +454,39 @@ func (s *AuthService) ProcessRefreshToken(ctx context.Context, oldOpaqueRefreshT
 	// 1. Hash the incoming opaque refresh token string.
 	oldTokenHash := hashToken(oldOpaqueRefreshTokenString)
 
-	// 2. validate the old token hash against the DB and fetch the user.
-	// ValidateAndFetchUserByTokenHash (from token_store.go) checks expiry too.
-	u, err := s.ts.ValidateAndFetchUserByTokenHash(ctx, oldTokenHash)
+	// 2. validate the old token hash against the DB, mark it rotated and fetch the user.
+	// this makes the old token unusable, except for one concurrent refresh within the
+	// grace period (see RotateRefreshToken). it checks expiry too.
+	u, graceUse, err := s.ts.RotateRefreshToken(ctx, oldTokenHash)
 	if err != nil {
-		// err could be ErrRefreshTokenNotFound from token_store.go
 		log.Printf("Opaque refresh token validation failed: %v (token was %s...)", err, oldOpaqueRefreshTokenString[:minhashes(len(oldOpaqueRefreshTokenString), 10)])
-		return nil, ErrInvalidToken // Return a generic error to the client
-	}
-
-	// 3. if valid, delete the old refresh token from DB (strict rotation).
-	// this makes the old token unusable immediately.
-	if err := s.ts.DeleteRefreshTokenByHash(ctx, oldTokenHash); err != nil {
-		// log this error since it could be critical.
-		// if deletion fails, the old token might still be valid if the client didn't discard it,
-		// though the client *should* replace it with the new one.
-		log.Printf("WARNING: Failed to delete old refresh token hash %s after validation for user %s: %v", oldTokenHash[:minhashes(len(oldTokenHash), 10)], u.ID, err)
-		// consider proceeding or returning an error. For now, we'll proceed if user was validated.
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			// unknown, expired or already rotated token: possibly a replayed one
+			s.audit.Record(ctx, uuid.Nil, audit.ActionTokenRefreshRejected, map[string]any{"tokenHashPrefix": oldTokenHash[:minhashes(len(oldTokenHash), 10)]})
+			return nil, ErrInvalidToken // Return a generic error to the client
+		}
+		// DB failures (including timeouts) are not the client's fault, don't report them as a bad token
+		return nil, fmt.Errorf("could not validate refresh token: %w", err)
 	}
 
-	// 4. generate a new access token.
-	newAccessToken, err := s.GenerateAccessToken(u)
+	// 3. generate a new access token.
+	newAccessToken, newAccessTokenExpiresAt, err := s.GenerateAccessToken(u)
 	if err != nil {
 		return nil, fmt.Errorf("could not generate new access token during refresh: %w", err)
 	}
 
-	// 5. generate a new opaque refresh token.
+	// a concurrent sibling of the refresh that rotated this token: that one already issued the
+	// successor refresh token (and its cookie), so only the access token is renewed here
+	if graceUse {
+		log.Printf("Refresh token reused within the grace period for user %s (ID: %s), access token only.", u.Email, u.ID)
+		s.audit.Record(ctx, u.ID, audit.ActionTokenRefresh, map[string]any{"graceUse": true})
+		return &RefreshTokenResponse{
+			AccessToken:          newAccessToken,
+			AccessTokenExpiresAt: newAccessTokenExpiresAt,
+		}, nil
+	}
+
+	// 4. generate a new opaque refresh token.
 	newOpaqueRefreshToken, err := generateOpaqueTokenString()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate new opaque refresh token: %w", err)
@@ -368,7 +494,7 @@ func (s *AuthService) ProcessRefreshToken(ctx context.Context, oldOpaqueRefreshT
 	newRefreshTokenHash := hashToken(newOpaqueRefreshToken)
 	newRefreshTokenExpiresAt := time.Now().Add(s.refreshTokenExpiration)
 
-	// 6. save hash of new opaque refresh token to the DB.
+	// 5. save hash of new opaque refresh token to the DB.
 	if err := s.ts.SaveRefreshToken(ctx, u.ID, newRefreshTokenHash, newRefreshTokenExpiresAt); err != nil {
 		// if saving the new token fails, this is a critical issue.
 		// the user might be left in a state where they can't refresh again with the new token.
@@ -378,8 +504,149 @@ func (s *AuthService) ProcessRefreshToken(ctx context.Context, oldOpaqueRefreshT
 	}
 
 	log.Printf("Tokens refreshed successfully using opaque token for user: %s (ID: %s). New opaque refresh token issued.", u.Email, u.ID)
+	s.audit.Record(ctx, u.ID, audit.ActionTokenRefresh, nil)
 	return &RefreshTokenResponse{
-		AccessToken:  newAccessToken,
-		RefreshToken: newOpaqueRefreshToken, // return raw opaque token for the cookie
+		AccessToken:          newAccessToken,
+		AccessTokenExpiresAt: newAccessTokenExpiresAt,
+		RefreshToken:         newOpaqueRefreshToken, // return raw opaque token for the cookie
 	}, nil
 }
+
+// LogoutUser revokes the given refresh token server-side.
+// an unknown or expired token is not an error: the session is already gone.
+func (s *AuthService) LogoutUser(ctx context.Context, opaqueRefreshTokenString string) error {
+	if opaqueRefreshTokenString == "" {
+		return nil
+	}
+	tokenHash := hashToken(opaqueRefreshTokenString)
+
+	// fetch the owner first, only for the audit trail
+	u, err := s.ts.ValidateAndFetchUserByTokenHash(ctx, tokenHash)
+	if err != nil && !errors.Is(err, ErrRefreshTokenNotFound) {
+		return fmt.Errorf("could not look up refresh token on logout: %w", err)
+	}
+
+	if err := s.ts.DeleteRefreshTokenByHash(ctx, tokenHash); err != nil {
+		return fmt.Errorf("could not revoke refresh token on logout: %w", err)
+	}
+
+	if u != nil {
+		log.Printf("User logged out: %s (ID: %s)", u.Email, u.ID)
+		s.audit.Record(ctx, u.ID, audit.ActionLogout, nil)
+	}
+	return nil
+}
+
+// generateDisplayName returns a random handle like "trader_3f9a1c07d2".
+func generateDisplayName() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate display name: %w", err)
+	}
+	return defaultDisplayNamePrefix + hex.EncodeToString(b), nil
+}
+
+// --- Display name
+
+// ChangeDisplayName sets the user's public display name, returning the updated user.
+// a name taken by someone else (in any case) gives ErrDisplayNameTaken.
+func (s *AuthService) ChangeDisplayName(ctx context.Context, userID uuid.UUID, displayName string) (*user.User, error) {
+	v := &ValidationError{}
+	validateDisplayName(v, displayName)
+	if err := v.orNil(); err != nil {
+		return nil, err
+	}
+
+	u, err := s.us.UpdateDisplayName(ctx, userID, displayName)
+	if err != nil {
+		return nil, fmt.Errorf("could not change display name: %w", err)
+	}
+	log.Printf("Display name of user %s changed to %s", userID, u.DisplayName)
+	return u, nil
+}
+
+// --- Email change
+
+// RequestEmailChange starts changing a user's email to newEmail.
+// the change is only applied once the token sent to the new address is confirmed
+// with ConfirmEmailChange, so until then the current email keeps working for login.
+func (s *AuthService) RequestEmailChange(ctx context.Context, userID uuid.UUID, newEmail string) error {
+	v := &ValidationError{}
+	validateEmail(v, newEmail)
+	if err := v.orNil(); err != nil {
+		return err
+	}
+
+	u, err := s.us.FindUserByIDInDB(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not load user for email change: %w", err)
+	}
+	if strings.EqualFold(u.Email, newEmail) {
+		return ErrEmailUnchanged
+	}
+
+	if s.emailChangeCooldown > 0 {
+		lastChange, changed, err := s.us.LastEmailChangeAt(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if retryAfter := time.Until(lastChange.Add(s.emailChangeCooldown)); changed && retryAfter > 0 {
+			return &EmailChangeCooldownError{Cooldown: s.emailChangeCooldown, RetryAfter: retryAfter}
+		}
+	}
+
+	// soft-deleted users still hold their address in the unique constraint, so include them
+	existingUser, err := s.us.FindUserByEmailIncludingDeletedInDB(ctx, newEmail)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("could not verify email availability: %w", err)
+	}
+	if existingUser != nil {
+		return ErrUserAlreadyExists
+	}
+
+	token, err := generateOpaqueTokenString()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	if err := s.us.SaveEmailChangeRequest(ctx, userID, newEmail, hashToken(token), time.Now().Add(emailChangeTokenExpiration)); err != nil {
+		return err
+	}
+
+	textBody, htmlBody, err := email.Render(email.TemplateEmailChange, email.TemplateData{
+		Name:      u.DisplayName,
+		Link:      email.Link(s.appBaseURL, "/email/confirm", token),
+		ExpiresIn: fmt.Sprintf("%d hours", int(emailChangeTokenExpiration.Hours())),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.mail.SendHTML(ctx, newEmail, "Confirm your new email address", textBody, htmlBody); err != nil {
+		log.Printf("Error sending email change confirmation for user %s: %v", userID, err)
+		return fmt.Errorf("could not send confirmation email: %w", err)
+	}
+
+	log.Printf("Email change requested for user %s", userID)
+	s.audit.Record(ctx, userID, audit.ActionEmailChangeRequest, map[string]any{"newEmail": newEmail})
+	return nil
+}
+
+// ConfirmEmailChange applies the pending email change matching token.
+// returns ErrInvalidToken when the token is unknown, expired or superseded by a newer request,
+// and an *EmailChangeCooldownError when another change was applied within the cooldown.
+func (s *AuthService) ConfirmEmailChange(ctx context.Context, token string) (*user.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	u, err := s.us.ApplyEmailChange(ctx, hashToken(token), s.emailChangeCooldown)
+	if err != nil {
+		if errors.Is(err, ErrEmailChangeNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	log.Printf("Email changed for user %s to %s", u.ID, u.Email)
+	s.audit.Record(ctx, u.ID, audit.ActionEmailChange, map[string]any{"newEmail": u.Email})
+	return u, nil
+}
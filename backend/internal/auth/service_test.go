@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testJWTSecret = "test-secret-that-is-at-least-32-bytes-long"
+
+// newTestAuthService returns a service with only the token settings set, enough for ValidateToken.
+func newTestAuthService() *AuthService {
+	return &AuthService{
+		jwtSecret:      testJWTSecret,
+		jwtIssuer:      "PaperTradingApp",
+		jwtLeeway:      30 * time.Second,
+		jwtExpiration:  15 * time.Minute,
+		jwtAllowedAlgs: []string{"HS256"},
+	}
+}
+
+// testClaims returns valid access token claims for a random user, expiring in 15 minutes.
+func testClaims() *JWTCustomClaims {
+	userID := uuid.New()
+	now := time.Now()
+	return &JWTCustomClaims{
+		UserID: userID,
+		Email:  "user@example.com",
+		Role:   "user",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "PaperTradingApp",
+			Subject:   userID.String(),
+		},
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, key any, claims *JWTCustomClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("signing test token: %v", err)
+	}
+	return token
+}
+
+func TestValidateTokenSigningMethods(t *testing.T) {
+	s := newTestAuthService()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "valid HS256",
+			token: signTestToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), testClaims()),
+		},
+		{
+			name:    "alg none",
+			token:   signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, testClaims()),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "HMAC alg outside the allowlist",
+			token:   signTestToken(t, jwt.SigningMethodHS512, []byte(testJWTSecret), testClaims()),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "RS256",
+			token:   signTestToken(t, jwt.SigningMethodRS256, rsaKey, testClaims()),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "HS256 with another secret",
+			token:   signTestToken(t, jwt.SigningMethodHS256, []byte("another-secret-that-is-32-bytes-long!"), testClaims()),
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := s.ValidateToken(tt.token)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("ValidateToken() error = %v, want nil", err)
+				}
+				if claims == nil || claims.UserID == uuid.Nil {
+					t.Fatalf("ValidateToken() returned no claims for a valid token")
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if claims != nil {
+				t.Fatalf("ValidateToken() returned claims for a rejected token")
+			}
+		})
+	}
+}
+
+func TestValidateTokenAllowlistIsConfigurable(t *testing.T) {
+	s := newTestAuthService()
+	s.jwtAllowedAlgs = []string{"HS256", "HS512"}
+
+	token := signTestToken(t, jwt.SigningMethodHS512, []byte(testJWTSecret), testClaims())
+	if _, err := s.ValidateToken(token); err != nil {
+		t.Fatalf("ValidateToken() error = %v for an allowlisted HS512 token", err)
+	}
+}
+
+func TestValidateTokenSubjectMustMatchUserID(t *testing.T) {
+	s := newTestAuthService()
+
+	tests := []struct {
+		name    string
+		subject string
+	}{
+		{"subject of another user", uuid.New().String()},
+		{"missing subject", ""},
+		{"subject not a uuid", "not-a-uuid"},
+		{"nil uuid subject", uuid.Nil.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			claims.Subject = tt.subject
+			token := signTestToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), claims)
+
+			if _, err := s.ValidateToken(token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestValidateTokenLeeway(t *testing.T) {
+	s := newTestAuthService() // 30s leeway
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		notBefore time.Time
+		wantErr   error
+	}{
+		{"expired within the leeway", now.Add(-10 * time.Second), now.Add(-time.Minute), nil},
+		{"expired past the leeway", now.Add(-time.Minute), now.Add(-2 * time.Minute), ErrTokenExpired},
+		{"not before within the leeway", now.Add(15 * time.Minute), now.Add(10 * time.Second), nil},
+		{"not before past the leeway", now.Add(15 * time.Minute), now.Add(time.Minute), ErrTokenNotValidYet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			claims.ExpiresAt = jwt.NewNumericDate(tt.expiresAt)
+			claims.NotBefore = jwt.NewNumericDate(tt.notBefore)
+			claims.IssuedAt = jwt.NewNumericDate(tt.notBefore)
+			token := signTestToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), claims)
+
+			_, err := s.ValidateToken(token)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("ValidateToken() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterUserConcurrentSameEmail(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestDBAuthService(t, pool, 0)
+	input := RegisterUserInput{Email: uniqueTestEmail(), Password: "password123"}
+
+	const callers = 10
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.RegisterUser(context.Background(), input)
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrUserAlreadyExists):
+			t.Errorf("RegisterUser() error = %v, want nil or %v", err, ErrUserAlreadyExists)
+		}
+	}
+	if created != 1 {
+		t.Errorf("registrations that succeeded = %d, want exactly 1", created)
+	}
+}
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestDBAuthService(t, pool, 0)
+	ctx := context.Background()
+
+	const password = "legacy password 1"
+	legacy, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword() error = %v", err)
+	}
+	u, err := s.us.CreateUserInDB(ctx, uniqueTestEmail(), string(legacy), formatPasswordAlgo(PasswordAlgoBcrypt, bcrypt.MinCost), "t_"+uuid.NewString()[:8])
+	if err != nil {
+		t.Fatalf("creating the test user: %v", err)
+	}
+
+	if _, err := s.LoginUser(ctx, LoginUserInput{Email: u.Email, Password: password}); err != nil {
+		t.Fatalf("LoginUser() error = %v", err)
+	}
+
+	// the rehash runs in the background after the login returns
+	wantAlgo := formatPasswordAlgo(PasswordAlgoBcryptSHA256, s.bcryptCost)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := s.us.FindUserByIDInDB(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("FindUserByIDInDB() error = %v", err)
+		}
+		if stored.PasswordAlgo == wantAlgo {
+			if !CheckPasswordHash(password, stored.PasswordHash, stored.PasswordAlgo) {
+				t.Fatal("the upgraded hash doesn't validate the password")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("password_algo = %q after login, want %q", stored.PasswordAlgo, wantAlgo)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// logging in again must still work, now against the upgraded hash
+	if _, err := s.LoginUser(ctx, LoginUserInput{Email: u.Email, Password: password}); err != nil {
+		t.Fatalf("LoginUser() after the upgrade error = %v", err)
+	}
+}
+
+func TestConfirmEmailChangeSupersededToken(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestDBAuthService(t, pool, 0)
+	ctx := context.Background()
+	u := createTestUser(t, s)
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := s.us.SaveEmailChangeRequest(ctx, u.ID, uniqueTestEmail(), hashToken("first token"), expiresAt); err != nil {
+		t.Fatalf("SaveEmailChangeRequest() error = %v", err)
+	}
+	newEmail := uniqueTestEmail()
+	if err := s.us.SaveEmailChangeRequest(ctx, u.ID, newEmail, hashToken("second token"), expiresAt); err != nil {
+		t.Fatalf("SaveEmailChangeRequest() error = %v", err)
+	}
+
+	if _, err := s.ConfirmEmailChange(ctx, "first token"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("ConfirmEmailChange() with the superseded token error = %v, want %v", err, ErrInvalidToken)
+	}
+	changed, err := s.ConfirmEmailChange(ctx, "second token")
+	if err != nil {
+		t.Fatalf("ConfirmEmailChange() with the latest token error = %v", err)
+	}
+	if changed.Email != newEmail {
+		t.Fatalf("email after confirmation = %q, want %q", changed.Email, newEmail)
+	}
+}
+
+func TestConfirmEmailChangeEnforcesCooldown(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestDBAuthService(t, pool, 0)
+	s.emailChangeCooldown = time.Hour
+	ctx := context.Background()
+	u := createTestUser(t, s)
+
+	// a change applied just now, like a request issued before it being confirmed afterwards
+	if _, err := pool.Exec(ctx, `insert into email_change_history (user_id, old_email, new_email) values ($1, $2, $3)`,
+		u.ID, uniqueTestEmail(), u.Email); err != nil {
+		t.Fatalf("recording the earlier change: %v", err)
+	}
+	if err := s.us.SaveEmailChangeRequest(ctx, u.ID, uniqueTestEmail(), hashToken("pending token"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SaveEmailChangeRequest() error = %v", err)
+	}
+
+	_, err := s.ConfirmEmailChange(ctx, "pending token")
+	var cooldownErr *EmailChangeCooldownError
+	if !errors.As(err, &cooldownErr) {
+		t.Fatalf("ConfirmEmailChange() error = %v, want an *EmailChangeCooldownError", err)
+	}
+	if cooldownErr.RetryAfter <= 0 || cooldownErr.RetryAfter > time.Hour {
+		t.Fatalf("RetryAfter = %s, want within the 1h cooldown", cooldownErr.RetryAfter)
+	}
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"backend/internal/database"
 	"backend/internal/user"
 	"context"
 	"errors"
@@ -20,67 +21,220 @@ var (
 
 type TokenStore struct {
 	db *pgxpool.Pool
+
+	// maxSessions caps the active refresh tokens per user, 0 means unlimited
+	maxSessions int
+	// refreshGrace is how long a rotated token may still be exchanged once, 0 means strict rotation
+	refreshGrace time.Duration
 }
 
-func NewTokenStore(db *pgxpool.Pool) *TokenStore {
+func NewTokenStore(db *pgxpool.Pool, maxSessions int, refreshGrace time.Duration) *TokenStore {
 	if db == nil {
 		log.Fatalf("Error: TokenStore initialized with a nil DB pool.")
 	}
-	return &TokenStore{db: db}
+	return &TokenStore{db: db, maxSessions: maxSessions, refreshGrace: refreshGrace}
+}
+
+// rowQuerier is what both the pool and a transaction offer for single-row queries.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
+// SaveRefreshToken stores a new refresh token. when the user already holds maxSessions
+// active tokens, the oldest ones are evicted first, logging those sessions out.
 func (s *TokenStore) SaveRefreshToken(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	ctx, done := database.StartQuery(ctx, "TokenStore.SaveRefreshToken")
+	defer done()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		database.LogQueryError(err, "Error starting transaction to save refresh token for user %s: %v", userID, err)
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op once committed
+
+	if s.maxSessions > 0 {
+		if err := s.evictOldestTokens(ctx, tx, userID); err != nil {
+			return err
+		}
+	}
+
 	query := `
 		INSERT INTO refresh_tokens (user_id, token_hash, expires_at)
 		VALUES ($1, $2, $3)
 	`
-	_, err := s.db.Exec(ctx, query, userID, tokenHash, expiresAt)
+	_, err = tx.Exec(ctx, query, userID, tokenHash, expiresAt)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
 			log.Printf("Error saving refresh token: unique constraint violation for token_hash. UserID: %s", userID)
 			return fmt.Errorf("failed to save refresh token due to conflict: %w", err)
 		}
-		log.Printf("Error saving refresh token to DB for user %s: %v", userID, err)
+		database.LogQueryError(err, "Error saving refresh token to DB for user %s: %v", userID, err)
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		database.LogQueryError(err, "Error committing refresh token for user %s: %v", userID, err)
 		return fmt.Errorf("failed to save refresh token: %w", err)
 	}
 	return nil
 }
 
-// ValidateAndFetchUserByTokenHash finds a refresh token by its hash, checks if it's valid (not expired),
-// and returns the associated user's User object.
+// evictOldestTokens deletes the user's oldest active tokens so one more fits under maxSessions.
+// the user row is locked so concurrent logins of the same user can't both slip under the limit.
+func (s *TokenStore) evictOldestTokens(ctx context.Context, tx pgx.Tx, userID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		database.LogQueryError(err, "Error locking user %s for session eviction: %v", userID, err)
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	count, err := countUserTokens(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	excess := count - s.maxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE id IN (
+			SELECT id FROM refresh_tokens
+			WHERE user_id = $1 AND expires_at > NOW() AND rotated_at IS NULL
+			ORDER BY created_at, id
+			LIMIT $2
+		)
+	`
+	commandTag, err := tx.Exec(ctx, query, userID, excess)
+	if err != nil {
+		database.LogQueryError(err, "Error evicting oldest refresh tokens for user %s: %v", userID, err)
+		return fmt.Errorf("failed to evict oldest refresh tokens: %w", err)
+	}
+	log.Printf("Evicted %d oldest session(s) of user %s (limit %d)", commandTag.RowsAffected(), userID, s.maxSessions)
+	return nil
+}
+
+// Session is a user's active refresh token, without the hash.
+type Session struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ListUserTokens returns the user's active refresh tokens, newest first.
+func (s *TokenStore) ListUserTokens(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	ctx, done := database.StartQuery(ctx, "TokenStore.ListUserTokens")
+	defer done()
+
+	query := `
+		SELECT id, created_at, expires_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND expires_at > NOW() AND rotated_at IS NULL
+		ORDER BY created_at DESC, id
+	`
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		database.LogQueryError(err, "Error listing refresh tokens for user %s: %v", userID, err)
+		return nil, fmt.Errorf("failed to list user's refresh tokens: %w", err)
+	}
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Session])
+	if err != nil {
+		database.LogQueryError(err, "Error scanning refresh tokens for user %s: %v", userID, err)
+		return nil, fmt.Errorf("failed to list user's refresh tokens: %w", err)
+	}
+	return sessions, nil
+}
+
+// CountUserTokens returns how many active (non-expired) refresh tokens the user holds.
+func (s *TokenStore) CountUserTokens(ctx context.Context, userID uuid.UUID) (int, error) {
+	ctx, done := database.StartQuery(ctx, "TokenStore.CountUserTokens")
+	defer done()
+
+	return countUserTokens(ctx, s.db, userID)
+}
+
+func countUserTokens(ctx context.Context, q rowQuerier, userID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT count(*) FROM refresh_tokens WHERE user_id = $1 AND expires_at > NOW() AND rotated_at IS NULL`
+	if err := q.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		database.LogQueryError(err, "Error counting refresh tokens for user %s: %v", userID, err)
+		return 0, fmt.Errorf("failed to count user's refresh tokens: %w", err)
+	}
+	return count, nil
+}
+
+// ValidateAndFetchUserByTokenHash finds a refresh token by its hash, checks if it's valid (not expired,
+// not rotated), and returns the associated user's User object. tokens of soft-deleted users are treated as not found.
 func (s *TokenStore) ValidateAndFetchUserByTokenHash(ctx context.Context, tokenHash string) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "TokenStore.ValidateAndFetchUserByTokenHash")
+	defer done()
+
 	query := `
-		SELECT u.id, u.email, u.password_hash, u.created_at, u.updated_at
+		SELECT ` + userColumns + `
 		FROM refresh_tokens rt
 		JOIN users u ON rt.user_id = u.id
-		WHERE rt.token_hash = $1 AND rt.expires_at > NOW()
+		WHERE rt.token_hash = $1 AND rt.expires_at > NOW() AND rt.rotated_at IS NULL AND u.deleted_at IS NULL
 	`
-	var u user.User
-	err := s.db.QueryRow(ctx, query, tokenHash).Scan(
-		&u.ID,
-		&u.Email,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	u, err := scanUser(s.db.QueryRow(ctx, query, tokenHash))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			// This means token not found OR found but expired.
 			return nil, ErrRefreshTokenNotFound
 		}
-		log.Printf("Error fetching user by refresh token hash: %v (hash was %s...)", err, tokenHash[:minhashes(len(tokenHash), 10)])
+		database.LogQueryError(err, "Error fetching user by refresh token hash: %v (hash was %s...)", err, tokenHash[:minhashes(len(tokenHash), 10)])
 		return nil, fmt.Errorf("error validating refresh token from DB: %w", err)
 	}
-	return &u, nil
+	return u, nil
+}
+
+// RotateRefreshToken marks a refresh token as exchanged and returns its user, for issuing the successor.
+//
+// strict rotation would log users out whenever an SPA fires several refreshes at once with the same
+// cookie: only the first would win. so a rotated token is accepted exactly once more within
+// refreshGrace (the concurrent sibling request), after which it's dead like an unknown token.
+// the check and the mark happen in a single UPDATE, so row locking makes concurrent calls see
+// each other: one rotates, one uses the grace, the rest get ErrRefreshTokenNotFound.
+//
+// graceUse is true for that extra use. the caller must not mint another refresh token for it:
+// the first call already issued the session's successor, and a second one would leave the
+// session with two live refresh tokens. only a new access token is issued then.
+func (s *TokenStore) RotateRefreshToken(ctx context.Context, tokenHash string) (u *user.User, graceUse bool, err error) {
+	ctx, done := database.StartQuery(ctx, "TokenStore.RotateRefreshToken")
+	defer done()
+
+	// SET expressions read the row as it was before the update
+	query := `
+		UPDATE refresh_tokens rt
+		SET rotated_at = COALESCE(rt.rotated_at, NOW()),
+			grace_used = rt.rotated_at IS NOT NULL
+		FROM users u
+		WHERE rt.token_hash = $1 AND rt.user_id = u.id
+		  AND rt.expires_at > NOW() AND u.deleted_at IS NULL
+		  AND (rt.rotated_at IS NULL OR (rt.rotated_at > NOW() - $2::interval AND NOT rt.grace_used))
+		RETURNING ` + userColumns + `, rt.grace_used`
+	u, err = scanUser(s.db.QueryRow(ctx, query, tokenHash, s.refreshGrace), &graceUse)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// unknown, expired, or rotated and past its grace
+			return nil, false, ErrRefreshTokenNotFound
+		}
+		database.LogQueryError(err, "Error rotating refresh token: %v (hash was %s...)", err, tokenHash[:minhashes(len(tokenHash), 10)])
+		return nil, false, fmt.Errorf("error rotating refresh token in DB: %w", err)
+	}
+	return u, graceUse, nil
 }
 
 // DeleteRefreshTokenByHash deletes a specific refresh token by its hash.
 func (s *TokenStore) DeleteRefreshTokenByHash(ctx context.Context, tokenHash string) error {
+	ctx, done := database.StartQuery(ctx, "TokenStore.DeleteRefreshTokenByHash")
+	defer done()
+
 	query := `DELETE FROM refresh_tokens WHERE token_hash = $1`
 	commandTag, err := s.db.Exec(ctx, query, tokenHash)
 	if err != nil {
-		log.Printf("Error deleting refresh token hash from DB: %v (hash was %s...)", err, tokenHash[:minhashes(len(tokenHash), 10)])
+		database.LogQueryError(err, "Error deleting refresh token hash from DB: %v (hash was %s...)", err, tokenHash[:minhashes(len(tokenHash), 10)])
 		return fmt.Errorf("failed to delete refresh token from DB: %w", err)
 	}
 	if commandTag.RowsAffected() == 0 {
@@ -90,23 +244,37 @@ func (s *TokenStore) DeleteRefreshTokenByHash(ctx context.Context, tokenHash str
 }
 
 // DeleteUserRefreshTokens deletes all refresh tokens associated with a specific user ID.
-func (s *TokenStore) DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
-	query := `DELETE FROM refresh_tokens WHERE user_id = $1`
-	commandTag, err := s.db.Exec(ctx, query, userID)
-	if err != nil {
-		log.Printf("Error deleting refresh tokens for user %s from DB: %v", userID, err)
-		return fmt.Errorf("failed to delete user's refresh tokens: %w", err)
+// it returns the number of active sessions among them, expired and rotated tokens are not counted.
+func (s *TokenStore) DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, done := database.StartQuery(ctx, "TokenStore.DeleteUserRefreshTokens")
+	defer done()
+
+	query := `
+		WITH deleted AS (
+			DELETE FROM refresh_tokens WHERE user_id = $1
+			RETURNING expires_at, rotated_at
+		)
+		SELECT count(*) FILTER (WHERE expires_at > NOW() AND rotated_at IS NULL) FROM deleted
+	`
+	var revoked int64
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&revoked); err != nil {
+		database.LogQueryError(err, "Error deleting refresh tokens for user %s from DB: %v", userID, err)
+		return 0, fmt.Errorf("failed to delete user's refresh tokens: %w", err)
 	}
-	log.Printf("Deleted %d refresh token(s) for user %s", commandTag.RowsAffected(), userID)
-	return nil
+	log.Printf("Revoked %d session(s) of user %s", revoked, userID)
+	return revoked, nil
 }
 
-// DeleteExpiredTokens manually deletes all expired refresh tokens from the database.
+// DeleteExpiredTokens manually deletes all expired refresh tokens from the database,
+// along with rotated ones past their grace period.
 func (s *TokenStore) DeleteExpiredTokens(ctx context.Context) (int64, error) {
-	query := `DELETE FROM refresh_tokens WHERE expires_at <= NOW()`
-	commandTag, err := s.db.Exec(ctx, query)
+	ctx, done := database.StartQuery(ctx, "TokenStore.DeleteExpiredTokens")
+	defer done()
+
+	query := `DELETE FROM refresh_tokens WHERE expires_at <= NOW() OR rotated_at <= NOW() - $1::interval`
+	commandTag, err := s.db.Exec(ctx, query, s.refreshGrace)
 	if err != nil {
-		log.Printf("Error deleting expired refresh tokens from DB: %v", err)
+		database.LogQueryError(err, "Error deleting expired refresh tokens from DB: %v", err)
 		return 0, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
 	}
 	log.Printf("Successfully deleted %d expired refresh token(s).", commandTag.RowsAffected())
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/user"
+
+	"github.com/google/uuid"
+)
+
+// createTestUser inserts a user with a throwaway password hash.
+func createTestUser(t *testing.T, s *AuthService) *user.User {
+	t.Helper()
+	hash, algo, err := HashPassword("password123", 4)
+	if err != nil {
+		t.Fatalf("hashing the test password: %v", err)
+	}
+	u, err := s.us.CreateUserInDB(context.Background(), uniqueTestEmail(), hash, algo, "t_"+uuid.NewString()[:8])
+	if err != nil {
+		t.Fatalf("creating the test user: %v", err)
+	}
+	return u
+}
+
+func TestProcessRefreshTokenConcurrent(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestDBAuthService(t, pool, 30*time.Second)
+	ctx := context.Background()
+
+	u := createTestUser(t, s)
+	refreshToken, err := generateOpaqueTokenString()
+	if err != nil {
+		t.Fatalf("generating the refresh token: %v", err)
+	}
+	if err := s.ts.SaveRefreshToken(ctx, u.ID, hashToken(refreshToken), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("saving the refresh token: %v", err)
+	}
+
+	const callers = 10
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		rotations int
+		graceUses int
+	)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.ProcessRefreshToken(ctx, refreshToken)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+			case err != nil:
+				t.Errorf("ProcessRefreshToken() unexpected error = %v", err)
+			case resp.AccessToken == "":
+				t.Errorf("ProcessRefreshToken() succeeded without an access token")
+			case resp.RefreshToken != "":
+				rotations++
+			default:
+				graceUses++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rotations != 1 {
+		t.Errorf("rotations = %d, want exactly 1", rotations)
+	}
+	if graceUses > 1 {
+		t.Errorf("grace uses = %d, want at most 1", graceUses)
+	}
+
+	active, err := s.ts.CountUserTokens(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("counting the active refresh tokens: %v", err)
+	}
+	if active != 1 {
+		t.Errorf("active refresh tokens = %d, want exactly 1", active)
+	}
+}
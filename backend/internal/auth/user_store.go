@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"backend/internal/database"
+	"backend/internal/pagination"
 	"backend/internal/user"
 	"context"
 	"errors"
@@ -10,14 +12,49 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
+	"strings"
+	"time"
 )
 
 // custom errors
 var (
-	ErrUserNotFound      = errors.New("user not found")
-	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserAlreadyExists   = errors.New("user already exists")
+	ErrLastAdmin           = errors.New("cannot demote the last remaining admin")
+	ErrEmailChangeNotFound = errors.New("email change request not found")
+	ErrDisplayNameTaken    = errors.New("display name already taken")
 )
 
+// displayNameIndex is the unique index on lower(display_name), to tell its violations apart from the email's.
+const displayNameIndex = "idx_users_display_name"
+
+// userColumns is the column list scanned by scanUser.
+// queries selecting it must alias the users table as "u".
+const userColumns = `u.id, u.email, u.password_hash, u.password_algo, u.role, u.email_verified, u.display_name, u.created_at, u.updated_at, u.deleted_at`
+
+// scanUser scans a row selected with userColumns into a user.User.
+// extra receives any columns selected after userColumns.
+func scanUser(row pgx.Row, extra ...any) (*user.User, error) {
+	var u user.User
+	dest := []any{
+		&u.ID,
+		&u.Email,
+		&u.PasswordHash,
+		&u.PasswordAlgo,
+		&u.Role,
+		&u.EmailVerified,
+		&u.DisplayName,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.DeletedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 type UserStore struct {
 	db *pgxpool.Pool
 }
@@ -29,83 +66,423 @@ func NewUserStore(db *pgxpool.Pool) *UserStore {
 	return &UserStore{db: db}
 }
 
-func (s *UserStore) CreateUserInDB(ctx context.Context, email string, passwordHash string) (*user.User, error) {
+// CreateUserInDB inserts a new user. a taken email gives ErrUserAlreadyExists, a taken
+// display name ErrDisplayNameTaken.
+func (s *UserStore) CreateUserInDB(ctx context.Context, email string, passwordHash string, passwordAlgo string, displayName string) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.CreateUserInDB")
+	defer done()
+
 	query := `
-		insert into public.users (email, password_hash) 
-		values ($1, $2) returning id, email, password_hash, created_at, updated_at
-	`
-	var u user.User
-	err := s.db.QueryRow(ctx, query, email, passwordHash).Scan(
-		&u.ID,
-		&u.Email,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+		insert into public.users as u (email, password_hash, password_algo, display_name) 
+		values ($1, $2, $3, $4) returning ` + userColumns
+	u, err := scanUser(s.db.QueryRow(ctx, query, email, passwordHash, passwordAlgo, displayName))
 
 	if err != nil {
 		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			// unique violation
-			if pgErr.Code == "23505" {
-				return nil, fmt.Errorf("user with email '%s' already exists: %w", email, ErrUserAlreadyExists)
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique violation
+			if pgErr.ConstraintName == displayNameIndex {
+				return nil, ErrDisplayNameTaken
 			}
-			log.Printf("Error finding user by email in DB: %v. Email: %s", err, email)
-			return nil, fmt.Errorf("could not find user by email: %w", err)
+			return nil, fmt.Errorf("user with email '%s' already exists: %w", email, ErrUserAlreadyExists)
 		}
+		// any other failure (query, scan, connection...) must not return a half-populated user
+		database.LogQueryError(err, "Error creating user in DB: %v. Email: %s", err, email)
+		return nil, fmt.Errorf("could not create user: %w", err)
 	}
 
-	return &u, nil
+	return u, nil
 }
 
-// FindUserByEmailInDB retrieves a user by their email address
+// FindUserByEmailInDB retrieves a user by their email address.
+// soft-deleted users are ignored.
 func (s *UserStore) FindUserByEmailInDB(ctx context.Context, email string) (*user.User, error) {
+	return s.findUserByEmail(ctx, email, false)
+}
+
+// FindUserByEmailIncludingDeletedInDB retrieves a user by their email address,
+// including soft-deleted users. callers must check user.IsDeleted themselves.
+func (s *UserStore) FindUserByEmailIncludingDeletedInDB(ctx context.Context, email string) (*user.User, error) {
+	return s.findUserByEmail(ctx, email, true)
+}
+
+func (s *UserStore) findUserByEmail(ctx context.Context, email string, includeDeleted bool) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.findUserByEmail")
+	defer done()
+
 	query := `
-		select id, email, password_hash, created_at, updated_at
-		from public.users
-		where email = $1
+		select ` + userColumns + `
+		from public.users u
+		where u.email = $1 and ($2 or u.deleted_at is null)
 	`
-	var u user.User
-	err := s.db.QueryRow(ctx, query, email).Scan(
-		&u.ID,
-		&u.Email,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	u, err := scanUser(s.db.QueryRow(ctx, query, email, includeDeleted))
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
-		log.Printf("Error finding user by email in DB: %v. Email: %s", err, email)
+		database.LogQueryError(err, "Error finding user by email in DB: %v. Email: %s", err, email)
 		return nil, fmt.Errorf("could not find user by email: %w", err)
 	}
-	return &u, nil
+	return u, nil
 }
 
-// FindUserByIDInDB retrieves a user by their ID
+// FindUserByIDInDB retrieves a user by their ID.
+// soft-deleted users are ignored.
 func (s *UserStore) FindUserByIDInDB(ctx context.Context, userID uuid.UUID) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.FindUserByIDInDB")
+	defer done()
+
 	query := `
-		select id, email, password_hash, created_at, updated_at
-		from public.users
-		where id = $1
+		select ` + userColumns + `
+		from public.users u
+		where u.id = $1 and u.deleted_at is null
 	`
-	var u user.User
-	err := s.db.QueryRow(ctx, query, userID).Scan(
-		&u.ID,
-		&u.Email,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	u, err := scanUser(s.db.QueryRow(ctx, query, userID))
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
-		log.Printf("Error finding user by ID in DB: %v. ID: %s", err, userID)
+		database.LogQueryError(err, "Error finding user by ID in DB: %v. ID: %s", err, userID)
 		return nil, fmt.Errorf("could not find user by ID: %w", err)
 	}
-	return &u, nil
+	return u, nil
+}
+
+// UpdatePasswordHash replaces a user's password hash and the algorithm that produced it.
+func (s *UserStore) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string, passwordAlgo string) error {
+	ctx, done := database.StartQuery(ctx, "UserStore.UpdatePasswordHash")
+	defer done()
+
+	query := `
+		update public.users
+		set password_hash = $2, password_algo = $3
+		where id = $1
+	`
+	commandTag, err := s.db.Exec(ctx, query, userID, passwordHash, passwordAlgo)
+	if err != nil {
+		database.LogQueryError(err, "Error updating password hash of user %s in DB: %v", userID, err)
+		return fmt.Errorf("could not update password hash: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SoftDeleteUser marks a user as deleted without removing the row.
+// the user can no longer log in or refresh tokens, but can be restored with RestoreUser.
+// rows are kept so a later purge job can hard-delete them past a retention window.
+func (s *UserStore) SoftDeleteUser(ctx context.Context, userID uuid.UUID) error {
+	ctx, done := database.StartQuery(ctx, "UserStore.SoftDeleteUser")
+	defer done()
+
+	query := `
+		update public.users
+		set deleted_at = now()
+		where id = $1 and deleted_at is null
+	`
+	commandTag, err := s.db.Exec(ctx, query, userID)
+	if err != nil {
+		database.LogQueryError(err, "Error soft-deleting user %s in DB: %v", userID, err)
+		return fmt.Errorf("could not soft-delete user: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	log.Printf("User soft-deleted: %s", userID)
+	return nil
+}
+
+// RestoreUser clears the soft-delete marker of a user.
+func (s *UserStore) RestoreUser(ctx context.Context, userID uuid.UUID) error {
+	ctx, done := database.StartQuery(ctx, "UserStore.RestoreUser")
+	defer done()
+
+	query := `
+		update public.users
+		set deleted_at = null
+		where id = $1 and deleted_at is not null
+	`
+	commandTag, err := s.db.Exec(ctx, query, userID)
+	if err != nil {
+		database.LogQueryError(err, "Error restoring user %s in DB: %v", userID, err)
+		return fmt.Errorf("could not restore user: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	log.Printf("User restored: %s", userID)
+	return nil
+}
+
+// ListUsersFilter narrows down the users returned by ListUsers.
+type ListUsersFilter struct {
+	// Search matches users whose email starts with it (case-insensitive). empty matches everyone.
+	Search string
+}
+
+// likeEscaper escapes the LIKE wildcards so user input is matched literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListUsers returns a page of non-deleted users ordered by creation date,
+// along with the total number of users matching the filter.
+func (s *UserStore) ListUsers(ctx context.Context, filter ListUsersFilter, page pagination.Page) ([]user.User, int, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.ListUsers")
+	defer done()
+
+	searchPattern := likeEscaper.Replace(filter.Search) + "%"
+
+	countQuery := `
+		select count(*)
+		from public.users u
+		where u.deleted_at is null and u.email ilike $1
+	`
+	var total int
+	if err := s.db.QueryRow(ctx, countQuery, searchPattern).Scan(&total); err != nil {
+		database.LogQueryError(err, "Error counting users in DB: %v", err)
+		return nil, 0, fmt.Errorf("could not count users: %w", err)
+	}
+
+	query := `
+		select ` + userColumns + `
+		from public.users u
+		where u.deleted_at is null and u.email ilike $1
+		order by u.created_at, u.id
+		limit $2 offset $3
+	`
+	rows, err := s.db.Query(ctx, query, searchPattern, page.Limit, page.Offset)
+	if err != nil {
+		database.LogQueryError(err, "Error listing users in DB: %v", err)
+		return nil, 0, fmt.Errorf("could not list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]user.User, 0, page.Limit)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			database.LogQueryError(err, "Error scanning user row: %v", err)
+			return nil, 0, fmt.Errorf("could not list users: %w", err)
+		}
+		users = append(users, *u)
+	}
+	if err := rows.Err(); err != nil {
+		database.LogQueryError(err, "Error iterating user rows: %v", err)
+		return nil, 0, fmt.Errorf("could not list users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// UpdateDisplayName sets the display name of a non-deleted user and returns the updated user.
+func (s *UserStore) UpdateDisplayName(ctx context.Context, userID uuid.UUID, displayName string) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.UpdateDisplayName")
+	defer done()
+
+	query := `
+		update public.users as u
+		set display_name = $2
+		where u.id = $1 and u.deleted_at is null
+		returning ` + userColumns
+	u, err := scanUser(s.db.QueryRow(ctx, query, userID, displayName))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		} else if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == displayNameIndex {
+			return nil, ErrDisplayNameTaken
+		}
+		database.LogQueryError(err, "Error updating display name of user %s in DB: %v", userID, err)
+		return nil, fmt.Errorf("could not update display name: %w", err)
+	}
+	return u, nil
+}
+
+// UpdateRole sets the role of a user and returns the updated user.
+// demoting the last remaining admin is refused with ErrLastAdmin.
+func (s *UserStore) UpdateRole(ctx context.Context, userID uuid.UUID, role string) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.UpdateRole")
+	defer done()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		database.LogQueryError(err, "Error starting transaction for role update of user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op once committed
+
+	// lock every admin row so concurrent demotions can't both see another admin left
+	adminsQuery := `
+		select id
+		from public.users
+		where role = 'admin' and deleted_at is null
+		for update
+	`
+	rows, err := tx.Query(ctx, adminsQuery)
+	if err != nil {
+		database.LogQueryError(err, "Error locking admin rows for role update of user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+	adminIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		database.LogQueryError(err, "Error reading admin rows for role update of user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+
+	if role != user.RoleAdmin && len(adminIDs) == 1 && adminIDs[0] == userID {
+		return nil, ErrLastAdmin
+	}
+
+	updateQuery := `
+		update public.users as u
+		set role = $2
+		where u.id = $1 and u.deleted_at is null
+		returning ` + userColumns
+	u, err := scanUser(tx.QueryRow(ctx, updateQuery, userID, role))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		database.LogQueryError(err, "Error updating role of user %s in DB: %v", userID, err)
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		database.LogQueryError(err, "Error committing role update of user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+	return u, nil
+}
+
+// SaveEmailChangeRequest stores a pending email change for a user, replacing any previous one.
+// the previous token hash is overwritten, so a confirmation link sent earlier stops working.
+func (s *UserStore) SaveEmailChangeRequest(ctx context.Context, userID uuid.UUID, newEmail string, tokenHash string, expiresAt time.Time) error {
+	ctx, done := database.StartQuery(ctx, "UserStore.SaveEmailChangeRequest")
+	defer done()
+
+	query := `
+		insert into public.email_change_requests (user_id, new_email, token_hash, expires_at)
+		values ($1, $2, $3, $4)
+		on conflict (user_id) do update
+		set new_email = excluded.new_email,
+			token_hash = excluded.token_hash,
+			expires_at = excluded.expires_at,
+			created_at = now()
+	`
+	if _, err := s.db.Exec(ctx, query, userID, newEmail, tokenHash, expiresAt); err != nil {
+		database.LogQueryError(err, "Error saving email change request for user %s: %v", userID, err)
+		return fmt.Errorf("could not save email change request: %w", err)
+	}
+	return nil
+}
+
+// ApplyEmailChange consumes a pending, non-expired email change by its token hash and
+// switches the user's email to the confirmed address, marking it verified.
+// the change is recorded in email_change_history within the same transaction.
+// returns ErrEmailChangeNotFound for unknown/expired tokens, ErrUserAlreadyExists
+// if the address was taken since the change was requested, and an *EmailChangeCooldownError
+// when another change was applied within cooldown (the request is then kept).
+func (s *UserStore) ApplyEmailChange(ctx context.Context, tokenHash string, cooldown time.Duration) (*user.User, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.ApplyEmailChange")
+	defer done()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		database.LogQueryError(err, "Error starting transaction for email change: %v", err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op once committed
+
+	var userID uuid.UUID
+	var newEmail string
+	deleteQuery := `
+		delete from public.email_change_requests
+		where token_hash = $1 and expires_at > now()
+		returning user_id, new_email
+	`
+	if err := tx.QueryRow(ctx, deleteQuery, tokenHash).Scan(&userID, &newEmail); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		database.LogQueryError(err, "Error consuming email change request: %v", err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+
+	var oldEmail string
+	oldEmailQuery := `select email from public.users where id = $1 and deleted_at is null for update`
+	if err := tx.QueryRow(ctx, oldEmailQuery, userID).Scan(&oldEmail); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		database.LogQueryError(err, "Error loading current email of user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+
+	// the cooldown is checked again here, not only when the token was issued: the user row is
+	// locked above, so two confirmations can't both slip through
+	if cooldown > 0 {
+		lastChange, changed, err := lastEmailChangeAt(ctx, tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if retryAfter := time.Until(lastChange.Add(cooldown)); changed && retryAfter > 0 {
+			return nil, &EmailChangeCooldownError{Cooldown: cooldown, RetryAfter: retryAfter}
+		}
+	}
+
+	updateQuery := `
+		update public.users as u
+		set email = $2, email_verified = true
+		where u.id = $1
+		returning ` + userColumns
+	u, err := scanUser(tx.QueryRow(ctx, updateQuery, userID, newEmail))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique violation
+			return nil, fmt.Errorf("email '%s' was taken before confirmation: %w", newEmail, ErrUserAlreadyExists)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		database.LogQueryError(err, "Error applying email change for user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+
+	historyQuery := `
+		insert into public.email_change_history (user_id, old_email, new_email)
+		values ($1, $2, $3)
+	`
+	if _, err := tx.Exec(ctx, historyQuery, userID, oldEmail, newEmail); err != nil {
+		database.LogQueryError(err, "Error recording email change history for user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		database.LogQueryError(err, "Error committing email change for user %s: %v", userID, err)
+		return nil, fmt.Errorf("could not apply email change: %w", err)
+	}
+	return u, nil
+}
+
+// LastEmailChangeAt returns when the user's email was last changed.
+// ok is false when it never was.
+func (s *UserStore) LastEmailChangeAt(ctx context.Context, userID uuid.UUID) (time.Time, bool, error) {
+	ctx, done := database.StartQuery(ctx, "UserStore.LastEmailChangeAt")
+	defer done()
+
+	return lastEmailChangeAt(ctx, s.db, userID)
+}
+
+func lastEmailChangeAt(ctx context.Context, q rowQuerier, userID uuid.UUID) (time.Time, bool, error) {
+	var changedAt *time.Time
+	query := `select max(changed_at) from public.email_change_history where user_id = $1`
+	if err := q.QueryRow(ctx, query, userID).Scan(&changedAt); err != nil {
+		database.LogQueryError(err, "Error fetching last email change of user %s: %v", userID, err)
+		return time.Time{}, false, fmt.Errorf("could not fetch last email change: %w", err)
+	}
+	if changedAt == nil {
+		return time.Time{}, false, nil
+	}
+	return *changedAt, true, nil
 }
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// passwordPolicy holds the configured password length bounds.
+// the minimum counts characters, the maximum bytes since that's what bounds the request size.
+type passwordPolicy struct {
+	minLength int
+	maxLength int
+}
+
+// ValidationError collects every invalid field of an input, keyed by the field's json name.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for field, msg := range e.Fields {
+		parts = append(parts, field+": "+msg)
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+// add records a problem with field. multiple problems on the same field are joined.
+func (e *ValidationError) add(field, msg string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	if existing, ok := e.Fields[field]; ok {
+		e.Fields[field] = existing + "; " + msg
+		return
+	}
+	e.Fields[field] = msg
+}
+
+// orNil returns nil when no problems were recorded, so callers can `return v.orNil()`.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateEmail checks that email is a bare address (no display name like `Bob <bob@x.com>`).
+func validateEmail(v *ValidationError, email string) {
+	if email == "" {
+		v.add("email", "is required")
+		return
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		v.add("email", "must be a valid email address")
+	}
+}
+
+// validatePassword checks the password against every complexity rule and reports each one that fails.
+func validatePassword(v *ValidationError, password string, policy passwordPolicy) {
+	if password == "" {
+		v.add("password", "is required")
+		return
+	}
+	if utf8.RuneCountInString(password) < policy.minLength {
+		v.add("password", fmt.Sprintf("must be at least %d characters long", policy.minLength))
+	}
+	if len(password) > policy.maxLength {
+		v.add("password", fmt.Sprintf("must be at most %d bytes long", policy.maxLength))
+	}
+	if !strings.ContainsFunc(password, unicode.IsLetter) {
+		v.add("password", "must contain at least one letter")
+	}
+	if !strings.ContainsFunc(password, unicode.IsDigit) {
+		v.add("password", "must contain at least one digit")
+	}
+}
+
+// display names are what other users see, so they are kept short and plain.
+const (
+	minDisplayNameLength = 3
+	maxDisplayNameLength = 32
+)
+
+// reservedDisplayNames could pass for staff, compared case-insensitively.
+var reservedDisplayNames = []string{"admin", "administrator", "moderator", "support", "system", "papertrading"}
+
+// validateDisplayName checks that name is 3-32 ASCII letters, digits, '_', '-' or '.', starting
+// with a letter or digit. the charset also rules out emails, '@' isn't in it.
+func validateDisplayName(v *ValidationError, name string) {
+	if name == "" {
+		v.add("displayName", "is required")
+		return
+	}
+	if len(name) < minDisplayNameLength || len(name) > maxDisplayNameLength {
+		v.add("displayName", fmt.Sprintf("must be between %d and %d characters long", minDisplayNameLength, maxDisplayNameLength))
+	}
+	valid := strings.IndexFunc(name, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.')
+	}) == -1
+	if !valid {
+		v.add("displayName", "may only contain letters, digits, '_', '-' and '.'")
+	} else if c := name[0]; c == '_' || c == '-' || c == '.' {
+		v.add("displayName", "must start with a letter or digit")
+	}
+	if slices.Contains(reservedDisplayNames, strings.ToLower(name)) {
+		v.add("displayName", "is reserved")
+	}
+}
+
+// validateRegisterInput returns a *ValidationError listing every invalid field, or nil.
+func validateRegisterInput(input RegisterUserInput, policy passwordPolicy) error {
+	v := &ValidationError{}
+	validateEmail(v, input.Email)
+	validatePassword(v, input.Password, policy)
+	return v.orNil()
+}
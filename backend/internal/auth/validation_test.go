@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRegisterInputPasswordLength(t *testing.T) {
+	policy := passwordPolicy{minLength: 8, maxLength: 72}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantField bool
+	}{
+		{"at the minimum", "abcdefg1", false},
+		{"under the minimum", "abcdef1", true},
+		{"at the maximum", strings.Repeat("a", 71) + "1", false},
+		{"over the maximum", strings.Repeat("a", 72) + "1", true},
+		// the maximum counts bytes, not characters: "é" takes two
+		{"multi-byte at the maximum", strings.Repeat("é", 35) + "1a", false},
+		{"multi-byte over the maximum", strings.Repeat("é", 36) + "1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRegisterInput(RegisterUserInput{Email: "user@example.com", Password: tt.password}, policy)
+			if !tt.wantField {
+				if err != nil {
+					t.Fatalf("validateRegisterInput() error = %v, want nil", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("validateRegisterInput() error = %v, want a *ValidationError", err)
+			}
+			if _, ok := validationErr.Fields["password"]; !ok {
+				t.Fatalf("validateRegisterInput() fields = %v, want the password field set", validationErr.Fields)
+			}
+			if _, ok := validationErr.Fields["email"]; ok {
+				t.Fatalf("validateRegisterInput() also flagged the valid email: %v", validationErr.Fields)
+			}
+		})
+	}
+}
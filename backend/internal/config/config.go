@@ -1,23 +1,133 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// feature flags, toggled per environment with FEATURE_FLAGS.
+// a flag is added together with the feature it gates.
+const (
+	FeatureCrypto = "crypto"
+)
+
+// MinJWTSecretLength is the minimum JWT_SECRET length in bytes: 32 bytes is the HS256 output
+// size, a shorter HMAC key weakens the signature.
+const MinJWTSecretLength = 32
+
+// weakJWTSecrets are placeholders that end up in deployments by accident, compared case-insensitively.
+var weakJWTSecrets = []string{"default", "secret", "changeme", "password", "jwt_secret", "your-secret-key"}
+
+// errors returned by checkJWTSecret, wrapped with the remedy.
+var (
+	ErrJWTSecretMissing  = errors.New("JWT_SECRET is not set")
+	ErrJWTSecretWeak     = errors.New("JWT_SECRET is a well-known placeholder")
+	ErrJWTSecretTooShort = errors.New("JWT_SECRET is too short")
+)
+
+// checkJWTSecret rejects an unset, placeholder or too short JWT secret.
+// the placeholder list is checked before the length on purpose: all of its entries are shorter
+// than MinJWTSecretLength, and naming the placeholder is more helpful than a length error.
+func checkJWTSecret(secret string) error {
+	if secret == "" || secret == "default" {
+		return fmt.Errorf("%w, generate one with `openssl rand -hex 32`", ErrJWTSecretMissing)
+	}
+	if slices.Contains(weakJWTSecrets, strings.ToLower(secret)) {
+		return fmt.Errorf("%w, generate one with `openssl rand -hex 32`", ErrJWTSecretWeak)
+	}
+	if len(secret) < MinJWTSecretLength {
+		return fmt.Errorf("%w: it must be at least %d bytes long, got %d", ErrJWTSecretTooShort, MinJWTSecretLength, len(secret))
+	}
+	return nil
+}
+
+// supportedJWTAlgs are the signing methods JWT_ALLOWED_ALGS may list: the HMAC ones, the only
+// ones the shared JWT secret can verify. "none" is never among them.
+var supportedJWTAlgs = []string{"HS256", "HS384", "HS512"}
+
+// defaultFeatures are the flags of an environment that sets none. experimental features stay dark.
+var defaultFeatures = map[string]bool{
+	FeatureCrypto: false,
+}
+
 // Config holds all configuration for the application.
 type Config struct {
-	AppPort  string
-	AppEnv   string
-	LogLevel string
+	AppHost   string // bind address, empty means all interfaces
+	AppSocket string // unix socket path, takes precedence over AppHost/AppPort when set
+	AppPort   string
+	AppEnv    string
+	LogLevel  string
+
+	AppBaseURL string // public URL of the frontend, used for links in emails
+
+	// OpenTelemetry tracing, disabled when OTLPEndpoint is empty
+	OTLPEndpoint    string
+	OTELServiceName string
+
+	// serve HTTPS directly when both are set, see TLSEnabled
+	TLSCertFile string
+	TLSKeyFile  string
+
+	HTTPTimeout     time.Duration // default per-request timeout
+	HTTPAuthTimeout time.Duration // tighter timeout for /api/auth
+
+	// http.Server connection timeouts, see .env.example for how they relate to HTTPTimeout
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+
+	CompressionEnabled bool // gzip/deflate JSON responses, leave off when a reverse proxy already compresses
+	CompressionMinSize int  // bytes, smaller responses are sent as is
+
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         int // seconds browsers may cache a preflight response
 
 	JWTSecret              string
+	JWTIssuer              string
+	JWTAudience            string        // empty means tokens carry no audience and none is checked
+	JWTLeeway              time.Duration // clock skew tolerated on exp/nbf/iat checks
+	JWTAllowedAlgs         []string      // signing methods accepted on incoming tokens, anything else is rejected
 	JWTExpiration          time.Duration
 	RefreshTokenExpiration time.Duration
+	AccessTokenInCookie    bool          // also deliver/accept the access token as an HttpOnly cookie
+	MaxSessionsPerUser     int           // active refresh tokens per user, the oldest are evicted past it. 0 disables the limit
+	RefreshTokenGrace      time.Duration // window in which a rotated refresh token is accepted once more
+
+	BcryptCost int // cost of new password hashes, lower-cost hashes are upgraded on login
+
+	PasswordMinLength int // in characters
+	PasswordMaxLength int // in bytes
+
+	EmailChangeCooldown time.Duration // minimum time between two email changes, 0 disables it
+
+	Features map[string]bool // feature flags by name, see FeatureEnabled
+
+	// outgoing mail, emails are only logged when SMTPHost is empty
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// service credentials for POST /api/auth/introspect, the endpoint is disabled when unset
+	IntrospectionClientID     string
+	IntrospectionClientSecret string
+
+	DatabaseURL string // full connection string, overrides the DB_* fields below when set
 
 	DBHost     string
 	DBPort     string
@@ -25,50 +135,325 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	DBSslMode  string
+
+	DBQueryTimeout     time.Duration
+	SlowQueryThreshold time.Duration // store calls slower than this are logged, 0 disables it
+
+	// startup connection retries, the interval doubles after each failed attempt
+	DBConnectMaxAttempts   int
+	DBConnectRetryInterval time.Duration
 }
 
 // Load loads configuration from environment variables.
 // It will attempt to load a .env file if present.
 func Load() (*Config, error) {
 	// attempt to load .env file.
-	// in production, variables are set directly or through docker secrets (needs implementation).
+	// in production, variables are set directly or through docker/k8s secrets, see getSecretEnv.
 	// the error is ignored because we don't strictly require a .env file.
 	_ = godotenv.Load(".env")
 
-	jwtExpMinutes, err := strconv.Atoi(getEnv("JWT_EXPIRATION_MINUTES", "15"))
+	jwtSecret, err := getSecretEnv("JWT_SECRET", "default") // fallback for error handling
+	if err != nil {
+		return nil, err
+	}
+	dbPassword, err := getSecretEnv("DB_PASSWORD", "") // on linux the default is empty, on others is postgres
+	if err != nil {
+		return nil, err
+	}
+	smtpPassword, err := getSecretEnv("SMTP_PASSWORD", "")
+	if err != nil {
+		return nil, err
+	}
+	databaseURL, err := getSecretEnv("DATABASE_URL", "")
+	if err != nil {
+		return nil, err
+	}
+	introspectionClientSecret, err := getSecretEnv("INTROSPECTION_CLIENT_SECRET", "")
 	if err != nil {
-		log.Printf("Warning: Invalid JWT_EXPIRATION_MINUTES, using default 15: %v", err)
-		jwtExpMinutes = 15
+		return nil, err
+	}
+
+	jwtExpiration := getDurationEnv("JWT_EXPIRATION", "JWT_EXPIRATION_MINUTES", time.Minute, 15*time.Minute)
+	refreshExpiration := getDurationEnv("REFRESH_TOKEN_EXPIRATION", "REFRESH_TOKEN_EXPIRATION_DAYS", 24*time.Hour, 7*24*time.Hour)
+
+	jwtLeeway, err := time.ParseDuration(getEnv("JWT_LEEWAY", "30s"))
+	if err != nil || jwtLeeway < 0 {
+		log.Printf("Warning: Invalid JWT_LEEWAY, using default 30s: %v", err)
+		jwtLeeway = 30 * time.Second
+	}
+
+	// tokens are signed with HS256 and verified with the shared secret, so only HMAC methods can
+	// verify at all. the allowlist exists so that once asymmetric signing lands, HS256 can be
+	// dropped and a public key can't be abused as an HMAC secret (algorithm confusion).
+	jwtAllowedAlgs := getListEnv("JWT_ALLOWED_ALGS", []string{"HS256"})
+	for _, alg := range jwtAllowedAlgs {
+		if !slices.Contains(supportedJWTAlgs, alg) {
+			return nil, fmt.Errorf("JWT_ALLOWED_ALGS contains unsupported signing method %q, supported: %s", alg, strings.Join(supportedJWTAlgs, ", "))
+		}
+	}
+	if !slices.Contains(jwtAllowedAlgs, "HS256") {
+		return nil, fmt.Errorf("JWT_ALLOWED_ALGS must include HS256, the method new tokens are signed with")
+	}
+
+	if jwtExpiration <= 0 || refreshExpiration <= 0 {
+		return nil, fmt.Errorf("token expirations must be positive (access: %s, refresh: %s)", jwtExpiration, refreshExpiration)
+	}
+	if jwtExpiration >= refreshExpiration {
+		return nil, fmt.Errorf("access token expiration (%s) must be shorter than refresh token expiration (%s)", jwtExpiration, refreshExpiration)
+	}
+
+	refreshGrace, err := time.ParseDuration(getEnv("REFRESH_TOKEN_GRACE_PERIOD", "10s"))
+	if err != nil || refreshGrace < 0 {
+		log.Printf("Warning: Invalid REFRESH_TOKEN_GRACE_PERIOD, using default 10s: %v", err)
+		refreshGrace = 10 * time.Second
 	}
 
-	refreshExpDays, err := strconv.Atoi(getEnv("REFRESH_TOKEN_EXPIRATION_DAYS", "7"))
+	maxSessions, err := strconv.Atoi(getEnv("MAX_SESSIONS_PER_USER", "10"))
+	if err != nil || maxSessions < 0 {
+		log.Printf("Warning: Invalid MAX_SESSIONS_PER_USER, using default 10: %v", err)
+		maxSessions = 10
+	}
+
+	bcryptCost, err := strconv.Atoi(getEnv("BCRYPT_COST", strconv.Itoa(bcrypt.DefaultCost)))
 	if err != nil {
-		log.Printf("Warning: Invalid REFRESH_TOKEN_EXPIRATION_DAYS, using default 7: %v", err)
-		refreshExpDays = 7
+		log.Printf("Warning: Invalid BCRYPT_COST, using default %d: %v", bcrypt.DefaultCost, err)
+		bcryptCost = bcrypt.DefaultCost
+	}
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, bcryptCost)
+	}
+
+	passwordMinLength, err := strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "8"))
+	if err != nil || passwordMinLength < 1 {
+		log.Printf("Warning: Invalid PASSWORD_MIN_LENGTH, using default 8: %v", err)
+		passwordMinLength = 8
+	}
+	passwordMaxLength, err := strconv.Atoi(getEnv("PASSWORD_MAX_LENGTH", "256"))
+	if err != nil || passwordMaxLength < 1 {
+		log.Printf("Warning: Invalid PASSWORD_MAX_LENGTH, using default 256: %v", err)
+		passwordMaxLength = 256
+	}
+	if passwordMinLength > passwordMaxLength {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH (%d) must not be greater than PASSWORD_MAX_LENGTH (%d)", passwordMinLength, passwordMaxLength)
+	}
+
+	emailChangeCooldown, err := time.ParseDuration(getEnv("EMAIL_CHANGE_COOLDOWN", "24h"))
+	if err != nil || emailChangeCooldown < 0 {
+		log.Printf("Warning: Invalid EMAIL_CHANGE_COOLDOWN, using default 24h: %v", err)
+		emailChangeCooldown = 24 * time.Hour
+	}
+
+	httpTimeout, err := time.ParseDuration(getEnv("HTTP_TIMEOUT", "60s"))
+	if err != nil || httpTimeout <= 0 {
+		log.Printf("Warning: Invalid HTTP_TIMEOUT, using default 60s: %v", err)
+		httpTimeout = 60 * time.Second
+	}
+	httpAuthTimeout, err := time.ParseDuration(getEnv("HTTP_AUTH_TIMEOUT", "10s"))
+	if err != nil || httpAuthTimeout <= 0 {
+		log.Printf("Warning: Invalid HTTP_AUTH_TIMEOUT, using default 10s: %v", err)
+		httpAuthTimeout = 10 * time.Second
+	}
+
+	serverReadTimeout := getPositiveDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second)
+	serverReadHeaderTimeout := getPositiveDurationEnv("SERVER_READ_HEADER_TIMEOUT", 5*time.Second)
+	serverWriteTimeout := getPositiveDurationEnv("SERVER_WRITE_TIMEOUT", 75*time.Second)
+	serverIdleTimeout := getPositiveDurationEnv("SERVER_IDLE_TIMEOUT", 120*time.Second)
+	if serverWriteTimeout <= httpTimeout {
+		log.Printf("Warning: SERVER_WRITE_TIMEOUT (%s) is not longer than HTTP_TIMEOUT (%s), timed out requests may get no response", serverWriteTimeout, httpTimeout)
+	}
+
+	compressionMinSize, err := strconv.Atoi(getEnv("COMPRESSION_MIN_SIZE", "1024"))
+	if err != nil || compressionMinSize < 0 {
+		log.Printf("Warning: Invalid COMPRESSION_MIN_SIZE, using default 1024: %v", err)
+		compressionMinSize = 1024
+	}
+
+	corsMaxAge, err := strconv.Atoi(getEnv("CORS_MAX_AGE", "300"))
+	if err != nil || corsMaxAge < 0 {
+		log.Printf("Warning: Invalid CORS_MAX_AGE, using default 300: %v", err)
+		corsMaxAge = 300
+	}
+
+	dbQueryTimeout, err := time.ParseDuration(getEnv("DB_QUERY_TIMEOUT", "5s"))
+	if err != nil || dbQueryTimeout <= 0 {
+		log.Printf("Warning: Invalid DB_QUERY_TIMEOUT, using default 5s: %v", err)
+		dbQueryTimeout = 5 * time.Second
+	}
+
+	slowQueryMs, err := strconv.Atoi(getEnv("SLOW_QUERY_MS", "200"))
+	if err != nil || slowQueryMs < 0 {
+		log.Printf("Warning: Invalid SLOW_QUERY_MS, using default 200: %v", err)
+		slowQueryMs = 200
+	}
+
+	dbConnectMaxAttempts, err := strconv.Atoi(getEnv("DB_CONNECT_MAX_ATTEMPTS", "10"))
+	if err != nil || dbConnectMaxAttempts < 1 {
+		log.Printf("Warning: Invalid DB_CONNECT_MAX_ATTEMPTS, using default 10: %v", err)
+		dbConnectMaxAttempts = 10
 	}
 
 	cfg := &Config{
-		AppPort:                getEnv("APP_PORT", "8080"),
-		AppEnv:                 getEnv("APP_ENV", "development"),
-		LogLevel:               getEnv("LOG_LEVEL", "info"),
-		JWTSecret:              getEnv("JWT_SECRET", "default"), // fallback for error handling
-		JWTExpiration:          time.Duration(jwtExpMinutes) * time.Minute,
-		RefreshTokenExpiration: time.Duration(refreshExpDays) * 24 * time.Hour,
-		DBHost:                 getEnv("DB_HOST", "localhost"),
-		DBPort:                 getEnv("DB_PORT", "5432"),
-		DBUser:                 getEnv("DB_USER", "postgres"),
-		DBPassword:             getEnv("DB_PASSWORD", ""), // on linux the default is empty, on others is postgres
-		DBName:                 getEnv("DB_NAME", "papertrading"),
-		DBSslMode:              getEnv("DB_SSLMODE", "disable"),
+		AppHost:                   getEnv("APP_HOST", ""),
+		AppSocket:                 getEnv("APP_SOCKET", ""),
+		AppPort:                   getEnv("APP_PORT", "8080"),
+		AppEnv:                    getEnv("APP_ENV", "development"),
+		LogLevel:                  getEnv("LOG_LEVEL", "info"),
+		AppBaseURL:                getEnv("APP_BASE_URL", "http://localhost:8001"),
+		OTLPEndpoint:              getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTELServiceName:           getEnv("OTEL_SERVICE_NAME", "papertrading-api"),
+		TLSCertFile:               getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:                getEnv("TLS_KEY_FILE", ""),
+		HTTPTimeout:               httpTimeout,
+		HTTPAuthTimeout:           httpAuthTimeout,
+		ServerReadTimeout:         serverReadTimeout,
+		ServerReadHeaderTimeout:   serverReadHeaderTimeout,
+		ServerWriteTimeout:        serverWriteTimeout,
+		ServerIdleTimeout:         serverIdleTimeout,
+		CompressionEnabled:        getEnv("COMPRESSION_ENABLED", "false") == "true",
+		CompressionMinSize:        compressionMinSize,
+		CORSAllowedOrigins:        getListEnv("CORS_ALLOWED_ORIGINS", []string{"http://localhost:8001"}),
+		CORSAllowedMethods:        getListEnv("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		CORSAllowedHeaders:        getListEnv("CORS_ALLOWED_HEADERS", []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key"}),
+		CORSExposedHeaders:        getListEnv("CORS_EXPOSED_HEADERS", []string{"Link", "Location", "X-Request-Id"}),
+		CORSMaxAge:                corsMaxAge,
+		JWTSecret:                 jwtSecret,
+		JWTIssuer:                 getEnv("JWT_ISSUER", "PaperTradingApp"),
+		JWTAudience:               getEnv("JWT_AUDIENCE", ""),
+		JWTLeeway:                 jwtLeeway,
+		JWTAllowedAlgs:            jwtAllowedAlgs,
+		JWTExpiration:             jwtExpiration,
+		RefreshTokenExpiration:    refreshExpiration,
+		AccessTokenInCookie:       getEnv("ACCESS_TOKEN_IN_COOKIE", "false") == "true",
+		MaxSessionsPerUser:        maxSessions,
+		RefreshTokenGrace:         refreshGrace,
+		BcryptCost:                bcryptCost,
+		PasswordMinLength:         passwordMinLength,
+		PasswordMaxLength:         passwordMaxLength,
+		EmailChangeCooldown:       emailChangeCooldown,
+		Features:                  getFeaturesEnv("FEATURE_FLAGS"),
+		SMTPHost:                  getEnv("SMTP_HOST", ""),
+		SMTPPort:                  getEnv("SMTP_PORT", "587"),
+		SMTPUsername:              getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:              smtpPassword,
+		SMTPFrom:                  getEnv("SMTP_FROM", ""),
+		IntrospectionClientID:     getEnv("INTROSPECTION_CLIENT_ID", ""),
+		IntrospectionClientSecret: introspectionClientSecret,
+		DatabaseURL:               databaseURL,
+		DBHost:                    getEnv("DB_HOST", "localhost"),
+		DBPort:                    getEnv("DB_PORT", "5432"),
+		DBUser:                    getEnv("DB_USER", "postgres"),
+		DBPassword:                dbPassword,
+		DBName:                    getEnv("DB_NAME", "papertrading"),
+		DBSslMode:                 getEnv("DB_SSLMODE", "disable"),
+		DBQueryTimeout:            dbQueryTimeout,
+		SlowQueryThreshold:        time.Duration(slowQueryMs) * time.Millisecond,
+		DBConnectMaxAttempts:      dbConnectMaxAttempts,
+		DBConnectRetryInterval:    getPositiveDurationEnv("DB_CONNECT_RETRY_INTERVAL", time.Second),
 	}
 
-	if cfg.JWTSecret == "default" || cfg.JWTSecret == "" {
-		log.Fatalf("WARNING: JWT_SECRET is not set or is using the default. This is insecure for production.")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if err := checkJWTSecret(cfg.JWTSecret); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
+// TLSEnabled reports whether the server terminates TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// FeatureEnabled reports whether the named feature flag is on. unknown names are off.
+func (c *Config) FeatureEnabled(name string) bool {
+	return c.Features[name]
+}
+
+// EnabledFeatures returns the names of the feature flags that are on, sorted.
+func (c *Config) EnabledFeatures() []string {
+	enabled := []string{}
+	for name, on := range c.Features {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	slices.Sort(enabled)
+	return enabled
+}
+
+// LogValue summarizes the effective configuration for the startup log line.
+// it implements slog.LogValuer. secrets (JWT secret, passwords, DATABASE_URL, client secrets)
+// are never part of it, not even partially: only whether optional ones are set.
+func (c *Config) LogValue() slog.Value {
+	mailer := "log"
+	if c.SMTPHost != "" {
+		mailer = "smtp"
+	}
+	database := slog.GroupValue(
+		slog.String("host", c.DBHost),
+		slog.String("port", c.DBPort),
+		slog.String("name", c.DBName),
+		slog.String("sslmode", c.DBSslMode),
+	)
+	if c.DatabaseURL != "" {
+		// the URL carries the password, and the DB_* fields are ignored
+		database = slog.GroupValue(slog.String("source", "DATABASE_URL"))
+	}
+
+	return slog.GroupValue(
+		slog.String("appEnv", c.AppEnv),
+		slog.String("host", c.AppHost),
+		slog.String("port", c.AppPort),
+		slog.String("socket", c.AppSocket),
+		slog.String("logLevel", c.LogLevel),
+		slog.Bool("tls", c.TLSEnabled()),
+		slog.Duration("httpTimeout", c.HTTPTimeout),
+		slog.Bool("compression", c.CompressionEnabled),
+		slog.Duration("accessTokenTTL", c.JWTExpiration),
+		slog.Duration("refreshTokenTTL", c.RefreshTokenExpiration),
+		slog.Bool("accessTokenInCookie", c.AccessTokenInCookie),
+		slog.Int("maxSessionsPerUser", c.MaxSessionsPerUser),
+		slog.Int("bcryptCost", c.BcryptCost),
+		slog.Any("features", c.EnabledFeatures()),
+		slog.String("mailer", mailer),
+		slog.Bool("tracing", c.OTLPEndpoint != ""),
+		slog.Bool("introspection", c.IntrospectionClientID != "" && c.IntrospectionClientSecret != ""),
+		slog.Any("database", database),
+		slog.Duration("dbQueryTimeout", c.DBQueryTimeout),
+	)
+}
+
+// getFeaturesEnv reads key as a comma-separated list of feature flags on top of defaultFeatures.
+// a bare name turns the flag on, name=true/name=false sets it explicitly.
+// unknown names and invalid values are ignored with a warning.
+func getFeaturesEnv(key string) map[string]bool {
+	features := make(map[string]bool, len(defaultFeatures))
+	for name, on := range defaultFeatures {
+		features[name] = on
+	}
+	for _, item := range getListEnv(key, nil) {
+		name, value, hasValue := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if _, known := defaultFeatures[name]; !known {
+			log.Printf("Warning: Unknown feature flag %q in %s, ignoring it", name, key)
+			continue
+		}
+		on := true
+		if hasValue {
+			var err error
+			if on, err = strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+				log.Printf("Warning: Invalid value for feature flag %q in %s, ignoring it: %v", name, key, err)
+				continue
+			}
+		}
+		features[name] = on
+	}
+	return features
+}
+
 // getEnv retrieves an environment variable or returns a default value.
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -76,3 +461,73 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getSecretEnv reads a secret from the file named by key+"_FILE" when that is set (the docker/k8s
+// secrets pattern), and from key itself otherwise. trailing newlines of the file are trimmed.
+// an unreadable file is an error rather than a silent fallback to the inline value.
+func getSecretEnv(key, fallback string) (string, error) {
+	path, exists := os.LookupEnv(key + "_FILE")
+	if !exists || path == "" {
+		return getEnv(key, fallback), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s_FILE: %w", key, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// getListEnv reads key as a comma-separated list, trimming spaces and dropping empty items.
+// an unset key gives fallback, an explicitly empty one gives an empty list.
+func getListEnv(key string, fallback []string) []string {
+	raw, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getPositiveDurationEnv reads key as a Go duration string, invalid or non-positive values fall back to the default.
+func getPositiveDurationEnv(key string, fallback time.Duration) time.Duration {
+	raw, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: Invalid %s, using default %s: %v", key, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+// getDurationEnv reads key as a Go duration string (e.g. "15m", "720h").
+// when key is unset, legacyKey is read as an integer count of legacyUnit, for compatibility
+// with the older *_MINUTES/*_DAYS variables. invalid values fall back to the default.
+func getDurationEnv(key, legacyKey string, legacyUnit, fallback time.Duration) time.Duration {
+	if raw, exists := os.LookupEnv(key); exists {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Printf("Warning: Invalid %s, using default %s: %v", key, fallback, err)
+			return fallback
+		}
+		return d
+	}
+
+	if raw, exists := os.LookupEnv(legacyKey); exists {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Printf("Warning: Invalid %s, using default %s: %v", legacyKey, fallback, err)
+			return fallback
+		}
+		return time.Duration(n) * legacyUnit
+	}
+
+	return fallback
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckJWTSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr error
+	}{
+		{"empty", "", ErrJWTSecretMissing},
+		{"old default", "default", ErrJWTSecretMissing},
+		{"placeholder", "secret", ErrJWTSecretWeak},
+		{"placeholder in another case", "ChangeMe", ErrJWTSecretWeak},
+		{"short", "abc123", ErrJWTSecretTooShort},
+		{"one byte short", strings.Repeat("a", MinJWTSecretLength-1), ErrJWTSecretTooShort},
+		{"exactly the minimum", strings.Repeat("a", MinJWTSecretLength), nil},
+		{"openssl rand -hex 32", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJWTSecret(tt.secret)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("checkJWTSecret() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkJWTSecret() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWeakJWTSecretsAreReachable(t *testing.T) {
+	// every placeholder must get the specific error, not be shadowed by another check
+	for _, secret := range weakJWTSecrets {
+		if secret == "default" {
+			continue // reported as missing, it is getSecretEnv's fallback
+		}
+		if err := checkJWTSecret(secret); !errors.Is(err, ErrJWTSecretWeak) {
+			t.Errorf("checkJWTSecret(%q) error = %v, want %v", secret, err, ErrJWTSecretWeak)
+		}
+	}
+}
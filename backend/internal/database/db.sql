@@ -4,13 +4,56 @@ CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
+    password_algo VARCHAR(32) NOT NULL DEFAULT 'bcrypt:10', -- "<algo>:<cost>" that produced password_hash
+    role VARCHAR(32) NOT NULL DEFAULT 'user', -- one of 'user', 'admin'
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    display_name VARCHAR(32) NOT NULL, -- public name (leaderboards...), so the email is never exposed
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ -- set when the user is soft-deleted, NULL otherwise
 );
 
 -- index on the email column for faster lookups
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 
+-- migrations for databases created before these columns existed, CREATE TABLE IF NOT EXISTS
+-- leaves an existing users table untouched and userColumns selects all of them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+-- existing rows get the legacy plain bcrypt algo, they are upgraded on the next login
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_algo VARCHAR(32) NOT NULL DEFAULT 'bcrypt:10';
+
+-- roles must be one of user.IsValidRole's, dropped and re-added so the statement stays idempotent
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'admin'));
+
+-- display names are unique regardless of case, so "Bob" can't impersonate "bob"
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(32);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_display_name ON users(lower(display_name));
+
+-- migration for databases created before display names: existing users get a random handle
+-- like the ones given at registration (nothing derived from their id, which must stay private),
+-- they can pick a real one afterwards. the index above is already in place, so a collision
+-- just draws another name
+DO $$
+DECLARE
+    uid UUID;
+BEGIN
+    FOR uid IN SELECT id FROM users WHERE display_name IS NULL LOOP
+        LOOP
+            BEGIN
+                UPDATE users SET display_name = 'trader_' || substr(md5(random()::text || clock_timestamp()::text), 1, 10)
+                WHERE id = uid;
+                EXIT;
+            EXCEPTION WHEN unique_violation THEN
+                NULL; -- taken, draw another
+            END;
+        END LOOP;
+    END LOOP;
+END $$;
+ALTER TABLE users ALTER COLUMN display_name SET NOT NULL;
+
 -- trigger to automatically update updated_at timestamp
 CREATE OR REPLACE FUNCTION trigger_set_timestamp()
 RETURNS TRIGGER AS $$
@@ -20,7 +63,7 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE TRIGGER set_users_updated_at
+CREATE OR REPLACE TRIGGER set_users_updated_at
 BEFORE UPDATE ON users
 FOR EACH ROW
 EXECUTE FUNCTION trigger_set_timestamp();
@@ -31,6 +74,8 @@ CREATE TABLE IF NOT EXISTS refresh_tokens (
     token_hash TEXT NOT NULL UNIQUE, -- the SHA256 hash of the opaque token
     expires_at TIMESTAMPTZ NOT NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ, -- set when the token is exchanged, it stays usable once more within the grace period
+    grace_used BOOLEAN NOT NULL DEFAULT FALSE, -- whether that one extra use happened
     -- last_used_at TIMESTAMPTZ, -- can be useful later on for tracking/cleanup
     -- revoked BOOLEAN NOT NULL DEFAULT FALSE, -- can be an alternative to deleting
 
@@ -40,9 +85,16 @@ CREATE TABLE IF NOT EXISTS refresh_tokens (
         ON DELETE CASCADE -- if a user is deleted, their refresh tokens are also deleted
 );
 
--- index for faster lookups by user_id, especially if a user can have multiple refresh tokens
--- (though the logic will aim for one active refresh token per user/device type).
-CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+-- migration for databases created before refresh_tokens had created_at
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+-- migration for databases created before the refresh grace period
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS grace_used BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- index for lookups by user_id, ordered by age for the sessions listing and oldest-first eviction.
+-- it also serves plain user_id lookups, so the former single-column index is dropped
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id_created_at ON refresh_tokens(user_id, created_at);
+DROP INDEX IF EXISTS idx_refresh_tokens_user_id;
 
 -- index for faster lookups by token_hash
 -- the UNIQUE constraint already creates an index, so this might be redundant depending on psql version
@@ -54,3 +106,50 @@ CREATE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens(token
 -- COMMENT ON COLUMN refresh_tokens.token_hash IS 'SHA256 hash of the opaque refresh token string.';
 -- COMMENT ON COLUMN refresh_tokens.expires_at IS 'Timestamp when this refresh token expires and is no longer valid.';
 -- COMMENT ON COLUMN refresh_tokens.created_at IS 'Timestamp when this refresh token record was created.';
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    actor_id UUID, -- NULL when the actor is unknown (e.g. failed login for an unknown email)
+    action VARCHAR(64) NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    ip TEXT,
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    -- no foreign key on actor_id: audit entries must outlive the users they reference
+);
+
+-- indexes for the admin audit listing filters, both ordered newest first
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id_created_at ON audit_log(actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_created_at ON audit_log(action, created_at DESC);
+
+-- pending email changes, applied once the new address is confirmed with the token sent to it
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL UNIQUE, -- at most one pending change per user
+    new_email VARCHAR(255) NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE, -- the SHA256 hash of the opaque confirmation token
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+-- applied email changes, kept as a trail for account takeover investigations and the change cooldown
+CREATE TABLE IF NOT EXISTS email_change_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    old_email VARCHAR(255) NOT NULL,
+    new_email VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+-- the cooldown check reads a user's latest change
+CREATE INDEX IF NOT EXISTS idx_email_change_history_user_id_changed_at ON email_change_history(user_id, changed_at DESC);
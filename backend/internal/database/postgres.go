@@ -3,52 +3,68 @@ package database
 import (
 	"backend/internal/config"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"sync"
+	"time"
 )
 
 var (
 	pool *pgxpool.Pool = nil
 	once sync.Once
+
+	// queryTimeout bounds every store call, see WithQueryTimeout.
+	queryTimeout = 5 * time.Second
+	// slowQueryThreshold is the duration past which StartQuery logs a store call, 0 disables it.
+	slowQueryThreshold = 200 * time.Millisecond
 )
 
 func InitPgxPool(ctx context.Context, cfg *config.Config) error {
 	var initErr error
 	once.Do(func() {
-		connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s&pool_max_conns=%d&pool_min_conns=%d",
-			cfg.DBUser,
-			cfg.DBPassword,
-			cfg.DBHost,
-			cfg.DBPort,
-			cfg.DBName,
-			cfg.DBSslMode,
-			4, // max connections, can also be from config
-			1, // min connections, can also be from config
-		)
-
-		log.Printf("PG connStr: %s", connStr)
-
-		pgxConfig, err := pgxpool.ParseConfig(connStr)
+		queryTimeout = cfg.DBQueryTimeout
+		slowQueryThreshold = cfg.SlowQueryThreshold
+
+		// parsing validates the connection string before any connection is attempted.
+		// pgx redacts the password in its parse errors
+		pgxConfig, err := pgxpool.ParseConfig(connString(cfg))
 		if err != nil {
 			initErr = fmt.Errorf("failed to parse pgxpool config: %w", err)
 			return
 		}
+		pgxConfig.ConnConfig.Tracer = newQueryTracer() // a no-op unless tracing is set up
 
-		p, err := pgxpool.NewWithConfig(ctx, pgxConfig)
-		if err != nil {
-			initErr = fmt.Errorf("failed to create pgxpool: %w", err)
-			return
-		}
+		// never log the connection string itself, it carries the password
+		log.Printf("Connecting to PostgreSQL at %s:%d, database %s, user %s",
+			pgxConfig.ConnConfig.Host, pgxConfig.ConnConfig.Port, pgxConfig.ConnConfig.Database, pgxConfig.ConnConfig.User)
 
-		// ping database to ensure connectivity
-		if err := p.Ping(ctx); err != nil {
-			p.Close() // close pool if ping fails
-			initErr = fmt.Errorf("failed to ping database with pgxpool: %w", err)
-			return
+		// the database may still be starting (e.g. in compose), so retry with exponential backoff
+		delay := cfg.DBConnectRetryInterval
+		for attempt := 1; ; attempt++ {
+			p, err := connect(ctx, pgxConfig)
+			if err == nil {
+				pool = p // assign to the global variable
+				break
+			}
+			if attempt >= cfg.DBConnectMaxAttempts {
+				initErr = fmt.Errorf("giving up after %d attempt(s): %w", attempt, err)
+				return
+			}
+
+			log.Printf("Database not ready (attempt %d/%d): %v. Retrying in %s", attempt, cfg.DBConnectMaxAttempts, err, delay)
+			select {
+			case <-ctx.Done():
+				initErr = fmt.Errorf("interrupted while waiting for the database: %w", ctx.Err())
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxConnectRetryInterval)
 		}
-		pool = p // assign to the global variable
 		log.Println("Successfully connected to PostgreSQL.")
 	})
 
@@ -61,6 +77,46 @@ func InitPgxPool(ctx context.Context, cfg *config.Config) error {
 	return nil
 }
 
+// maxConnectRetryInterval caps the backoff between connection attempts.
+const maxConnectRetryInterval = 30 * time.Second
+
+// connect opens a pool and pings the database, so a reachable but not yet ready server counts as a failure.
+func connect(ctx context.Context, pgxConfig *pgxpool.Config) (*pgxpool.Pool, error) {
+	p, err := pgxpool.NewWithConfig(ctx, pgxConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pgxpool: %w", err)
+	}
+
+	// ping database to ensure connectivity
+	if err := p.Ping(ctx); err != nil {
+		p.Close() // close pool if ping fails
+		return nil, fmt.Errorf("failed to ping database with pgxpool: %w", err)
+	}
+	return p, nil
+}
+
+// connString returns DATABASE_URL when set, and otherwise assembles one from the DB_* fields.
+// the fields are escaped through net/url, so special characters in e.g. the password are safe.
+func connString(cfg *config.Config) string {
+	if cfg.DatabaseURL != "" {
+		return cfg.DatabaseURL
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", cfg.DBSslMode)
+	query.Set("pool_max_conns", "4") // max connections, can also be from config
+	query.Set("pool_min_conns", "1") // min connections, can also be from config
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.DBUser, cfg.DBPassword),
+		Host:     net.JoinHostPort(cfg.DBHost, cfg.DBPort),
+		Path:     "/" + cfg.DBName,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
 // GetPool returns the initialized PostgreSQL connection pool.
 // will panic if the pool has not been initialized by calling InitPgxPool.
 func GetPool() *pgxpool.Pool {
@@ -88,3 +144,70 @@ func GetPgConn() (*pgxpool.Conn, error) {
 
 	return conn, nil
 }
+
+// WithQueryTimeout derives a context bounded by the configured DB query timeout (DB_QUERY_TIMEOUT).
+// stores wrap the incoming request context with it (through StartQuery) so a stuck database can't hold
+// a request indefinitely.
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// StartQuery prepares ctx for a store call named name (e.g. "UserStore.ListUsers"): it applies
+// the query timeout like WithQueryTimeout, and the returned done function, to be deferred,
+// also logs the call at warn level when it took longer than SLOW_QUERY_MS.
+// only the name and duration are logged, never query parameters (emails, hashes...).
+func StartQuery(ctx context.Context, name string) (context.Context, func()) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	start := time.Now()
+	return ctx, func() {
+		cancel()
+		if elapsed := time.Since(start); slowQueryThreshold > 0 && elapsed > slowQueryThreshold {
+			slog.WarnContext(ctx, "slow query",
+				slog.String("query", name),
+				slog.Int64("duration_ms", elapsed.Milliseconds()),
+				slog.Int64("threshold_ms", slowQueryThreshold.Milliseconds()),
+			)
+		}
+	}
+}
+
+// IsQueryTimeout reports whether err was caused by a query running past its deadline.
+// pgx wraps context errors, so this holds for errors returned (and wrapped) by the stores.
+func IsQueryTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// LogQueryError logs a failed store call like log.Printf, unless err is a context cancellation:
+// the client went away, so it is logged at debug level rather than as an error.
+func LogQueryError(err error, format string, args ...any) {
+	if errors.Is(err, context.Canceled) {
+		slog.Debug("query canceled", slog.String("error", fmt.Sprintf(format, args...)))
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// PoolStatistics is a snapshot of the connection pool, for diagnosing pool exhaustion.
+type PoolStatistics struct {
+	TotalConns      int32 `json:"totalConns"`
+	IdleConns       int32 `json:"idleConns"`
+	AcquiredConns   int32 `json:"acquiredConns"`
+	MaxConns        int32 `json:"maxConns"`
+	AcquireCount    int64 `json:"acquireCount"`
+	EmptyAcquires   int64 `json:"emptyAcquireCount"` // acquires that had to wait for a connection
+	AcquireDuration int64 `json:"acquireDurationMs"` // total time spent waiting in acquires
+}
+
+// PoolStats returns the current statistics of the pool set up by InitPgxPool.
+func PoolStats() PoolStatistics {
+	stat := GetPool().Stat()
+	return PoolStatistics{
+		TotalConns:      stat.TotalConns(),
+		IdleConns:       stat.IdleConns(),
+		AcquiredConns:   stat.AcquiredConns(),
+		MaxConns:        stat.MaxConns(),
+		AcquireCount:    stat.AcquireCount(),
+		EmptyAcquires:   stat.EmptyAcquireCount(),
+		AcquireDuration: stat.AcquireDuration().Milliseconds(),
+	}
+}
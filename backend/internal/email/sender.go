@@ -0,0 +1,214 @@
+package email
+
+import (
+	"backend/internal/config"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader is returned when a recipient or subject would inject extra mail headers.
+var ErrInvalidHeader = errors.New("email header contains a line break")
+
+// EmailSender sends emails. flows needing mail (verification, email change...)
+// depend on this interface, so they can be exercised with a mock sender.
+type EmailSender interface {
+	// Send sends a plain-text email.
+	Send(ctx context.Context, to, subject, body string) error
+	// SendHTML sends a multipart/alternative email, textBody being the fallback for plain-text clients.
+	SendHTML(ctx context.Context, to, subject, textBody, htmlBody string) error
+}
+
+// ErrSMTPNotConfigured is returned by NewSender in production without SMTP_HOST: mail would
+// silently never be delivered.
+var ErrSMTPNotConfigured = errors.New("SMTP_HOST must be set in production")
+
+// NewSender returns an SMTPSender when SMTP_HOST is configured, and a LogSender otherwise.
+// outside of development a missing SMTP config is an error rather than a silent fallback.
+func NewSender(cfg *config.Config) (EmailSender, error) {
+	if cfg == nil {
+		log.Fatal("Email sender: config cannot be nil")
+	}
+	if cfg.SMTPHost == "" {
+		if cfg.AppEnv == "production" {
+			return nil, ErrSMTPNotConfigured
+		}
+		log.Println("SMTP_HOST not set, emails will only be logged.")
+		return NewLogSender(), nil
+	}
+	return NewSMTPSender(cfg), nil
+}
+
+// --- SMTP
+
+// SMTPSender sends emails through an SMTP server, upgrading to TLS with STARTTLS when offered.
+type SMTPSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPSender(cfg *config.Config) *SMTPSender {
+	if cfg.SMTPFrom == "" {
+		log.Fatal("Email sender: SMTP_FROM must be set when SMTP_HOST is")
+	}
+	return &SMTPSender{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+	}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	return s.SendHTML(ctx, to, subject, body, "")
+}
+
+func (s *SMTPSender) SendHTML(ctx context.Context, to, subject, textBody, htmlBody string) error {
+	msg, err := buildMessage(s.from, to, subject, textBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp has no context support, so the dial honors ctx and its deadline bounds the whole exchange
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(s.host, s.port))
+	if err != nil {
+		return fmt.Errorf("could not connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("could not start SMTP session: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("could not start TLS with SMTP server: %w", err)
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection (except to localhost)
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP RCPT TO failed: %w", err)
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := wc.Write(msg); err != nil {
+		wc.Close()
+		return fmt.Errorf("could not write email body: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the email: %w", err)
+	}
+	return c.Quit()
+}
+
+// buildMessage formats an RFC 5322 message: plain text only when htmlBody is empty,
+// multipart/alternative with both versions otherwise.
+func buildMessage(from, to, subject, textBody, htmlBody string) ([]byte, error) {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if htmlBody == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&b, textBody); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	b.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+	// least preferred first (RFC 2046 section 5.1.4), clients show the last part they support
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", textBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create email part: %w", err)
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("could not finish multipart email: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// writeQuotedPrintable encodes body, which keeps long lines and non-ASCII text within SMTP limits.
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return fmt.Errorf("could not encode email body: %w", err)
+	}
+	if err := qw.Close(); err != nil {
+		return fmt.Errorf("could not encode email body: %w", err)
+	}
+	return nil
+}
+
+// --- Logging
+
+// LogSender only logs that an email would have been sent, for development without an SMTP server.
+// the body is never logged: it carries confirmation links whose tokens must not end up in logs.
+// to read the emails locally, point SMTP_HOST at a mail catcher such as Mailpit instead.
+type LogSender struct{}
+
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("Email to %s, subject %q (%d bytes, body not logged)", to, subject, len(body))
+	return nil
+}
+
+// SendHTML logs the plain-text version only, it carries the same content.
+func (s *LogSender) SendHTML(ctx context.Context, to, subject, textBody, htmlBody string) error {
+	return s.Send(ctx, to, subject, textBody)
+}
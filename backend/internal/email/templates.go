@@ -0,0 +1,51 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+)
+
+// available templates. each one has a .html and a .txt file under templates/.
+const (
+	TemplateEmailChange = "email_change"
+)
+
+//go:embed templates/*.html templates/*.txt
+var templateFS embed.FS
+
+// parsed once at startup, a broken template is a programming error.
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+)
+
+// TemplateData is what templates can reference.
+type TemplateData struct {
+	Name      string // the recipient's name, templates fall back to a generic greeting when empty
+	Link      string // absolute link with the token, see Link
+	ExpiresIn string // how long the link stays valid, human readable
+}
+
+// Render executes both variants of a template, for a multipart/alternative email.
+// the html variant is escaped by html/template, so data may come from users.
+func Render(name string, data TemplateData) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return "", "", fmt.Errorf("could not render text email %s: %w", name, err)
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return "", "", fmt.Errorf("could not render html email %s: %w", name, err)
+	}
+	return text.String(), html.String(), nil
+}
+
+// Link builds the absolute frontend link for path carrying token, e.g.
+// Link("https://app.example.com/", "/email/confirm", "abc") is "https://app.example.com/email/confirm?token=abc".
+func Link(baseURL, path, token string) string {
+	return strings.TrimRight(baseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Setup installs a JSON slog logger on stdout as the default logger and returns it.
+// the standard log package is routed through it too, so existing log.Printf calls
+// come out as JSON at info level.
+func Setup(level string) *slog.Logger {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: parseLevel(level)}))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// parseLevel maps LOG_LEVEL to a slog level, unknown values mean info.
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// --- Request fields
+
+type contextKey string
+
+const requestFieldsKey contextKey = "logRequestFields"
+
+// requestFields are filled in while a request is handled, for the request log line.
+// they live behind a pointer because the values are only known deeper in the middleware
+// chain (e.g. the user id after authentication) than where the line is written.
+type requestFields struct {
+	userID uuid.UUID
+}
+
+// WithRequestFields prepares ctx to collect request fields, see SetUserID.
+func WithRequestFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestFieldsKey, &requestFields{})
+}
+
+// SetUserID records the authenticated user of the request. it is a no-op when ctx
+// does not come from WithRequestFields.
+func SetUserID(ctx context.Context, userID uuid.UUID) {
+	if fields, ok := ctx.Value(requestFieldsKey).(*requestFields); ok {
+		fields.userID = userID
+	}
+}
+
+// UserID returns the user recorded with SetUserID, if any.
+func UserID(ctx context.Context) (uuid.UUID, bool) {
+	fields, ok := ctx.Value(requestFieldsKey).(*requestFields)
+	if !ok || fields.userID == uuid.Nil {
+		return uuid.Nil, false
+	}
+	return fields.userID, true
+}
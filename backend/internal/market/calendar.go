@@ -0,0 +1,186 @@
+package market
+
+import (
+	"log"
+	"time"
+	_ "time/tzdata" // embed the tz database so America/New_York resolves in minimal containers
+)
+
+// asset classes known to the calendar.
+const (
+	AssetClassEquity = "equity"
+	AssetClassCrypto = "crypto"
+)
+
+// MarketCalendar knows when the equity exchange is open.
+// crypto trades around the clock, so it is always reported as open.
+type MarketCalendar struct {
+	loc       *time.Location
+	openTime  time.Duration // offset from local midnight
+	closeTime time.Duration // offset from local midnight
+	holidays  map[string]struct{}
+	// holidayRule returns the recurring holidays of a year as YYYY-MM-DD, nil when there are none
+	holidayRule func(year int) []string
+}
+
+// NewMarketCalendar creates a calendar for an exchange in loc, open between openTime and closeTime
+// (offsets from local midnight) on weekdays that are not in holidays (YYYY-MM-DD).
+func NewMarketCalendar(loc *time.Location, openTime, closeTime time.Duration, holidays []string) *MarketCalendar {
+	if loc == nil {
+		log.Fatal("MarketCalendar: location cannot be nil")
+	}
+	h := make(map[string]struct{}, len(holidays))
+	for _, day := range holidays {
+		h[day] = struct{}{}
+	}
+	return &MarketCalendar{loc: loc, openTime: openTime, closeTime: closeTime, holidays: h}
+}
+
+// NewNYSECalendar creates a calendar with NYSE regular hours (09:30-16:00 America/New_York).
+func NewNYSECalendar() *MarketCalendar {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		log.Fatalf("MarketCalendar: failed to load America/New_York: %v", err)
+	}
+	c := NewMarketCalendar(loc, 9*time.Hour+30*time.Minute, 16*time.Hour, nil)
+	c.holidayRule = nyseHolidays
+	return c
+}
+
+// nyseHolidays returns the full-day NYSE closures of year as YYYY-MM-DD, computed by the
+// exchange's rules so the calendar doesn't run out. a holiday on a Sunday is observed the
+// Monday after and one on a Saturday the Friday before, except New Year's Day, which then isn't
+// made up (the Friday closes the previous year). early closes (e.g. the day after Thanksgiving)
+// and one-off closures are treated as regular days.
+func nyseHolidays(year int) []string {
+	days := []time.Time{
+		nthWeekday(year, time.January, time.Monday, 3),  // Martin Luther King Jr. Day
+		nthWeekday(year, time.February, time.Monday, 3), // Washington's Birthday
+		easter(year).AddDate(0, 0, -2),                  // Good Friday
+		lastWeekday(year, time.May, time.Monday),        // Memorial Day
+		observed(date(year, time.July, 4)),
+		nthWeekday(year, time.September, time.Monday, 1),  // Labor Day
+		nthWeekday(year, time.November, time.Thursday, 4), // Thanksgiving
+		observed(date(year, time.December, 25)),
+	}
+	if newYear := date(year, time.January, 1); newYear.Weekday() != time.Saturday {
+		days = append(days, observed(newYear))
+	}
+	if year >= 2022 { // Juneteenth, first observed in 2022
+		days = append(days, observed(date(year, time.June, 19)))
+	}
+
+	holidays := make([]string, len(days))
+	for i, day := range days {
+		holidays[i] = day.Format(time.DateOnly)
+	}
+	return holidays
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// observed moves a holiday falling on a weekend to the closest weekday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// nthWeekday returns the n-th weekday of month, e.g. the 3rd Monday of January.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	first := date(year, month, 1)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// lastWeekday returns the last weekday of month, e.g. the last Monday of May.
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	last := date(year, month+1, 0) // day 0 normalizes to the last day of month
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.AddDate(0, 0, -offset)
+}
+
+// easter returns Easter Sunday of year (Gregorian calendar, anonymous Gregorian algorithm).
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+// IsOpen reports whether assetClass can trade at t.
+// unknown asset classes are treated like equities, the stricter of the two.
+func (c *MarketCalendar) IsOpen(t time.Time, assetClass string) bool {
+	if assetClass == AssetClassCrypto {
+		return true
+	}
+
+	local := t.In(c.loc)
+	if !c.isTradingDay(local) {
+		return false
+	}
+	sinceMidnight := local.Sub(midnight(local))
+	return sinceMidnight >= c.openTime && sinceMidnight < c.closeTime
+}
+
+// NextOpen returns the next equity session open strictly after t.
+func (c *MarketCalendar) NextOpen(t time.Time) time.Time {
+	local := t.In(c.loc)
+	for day := midnight(local); ; day = day.AddDate(0, 0, 1) {
+		open := day.Add(c.openTime)
+		if c.isTradingDay(day) && open.After(local) {
+			return open
+		}
+	}
+}
+
+// NextClose returns the next equity session close strictly after t.
+func (c *MarketCalendar) NextClose(t time.Time) time.Time {
+	local := t.In(c.loc)
+	for day := midnight(local); ; day = day.AddDate(0, 0, 1) {
+		closeAt := day.Add(c.closeTime)
+		if c.isTradingDay(day) && closeAt.After(local) {
+			return closeAt
+		}
+	}
+}
+
+// isTradingDay reports whether the local date of t is a weekday and not a holiday.
+func (c *MarketCalendar) isTradingDay(t time.Time) bool {
+	switch t.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	day := t.Format(time.DateOnly)
+	if _, holiday := c.holidays[day]; holiday {
+		return false
+	}
+	if c.holidayRule != nil {
+		for _, holiday := range c.holidayRule(t.Year()) {
+			if holiday == day {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// midnight returns the start of t's day in t's location.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,64 @@
+package market
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNYSEHolidays(t *testing.T) {
+	// from the exchange's published schedules
+	tests := map[int][]string{
+		2026: {
+			"2026-01-01", "2026-01-19", "2026-02-16", "2026-04-03", "2026-05-25",
+			"2026-06-19", "2026-07-03", "2026-09-07", "2026-11-26", "2026-12-25",
+		},
+		2027: {
+			"2027-01-01", "2027-01-18", "2027-02-15", "2027-03-26", "2027-05-31",
+			"2027-06-18", "2027-07-05", "2027-09-06", "2027-11-25", "2027-12-24",
+		},
+		// New Year's Day falls on a Saturday and is not observed
+		2028: {
+			"2028-01-17", "2028-02-21", "2028-04-14", "2028-05-29", "2028-06-19",
+			"2028-07-04", "2028-09-04", "2028-11-23", "2028-12-25",
+		},
+	}
+
+	for year, want := range tests {
+		got := nyseHolidays(year)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("nyseHolidays(%d) = %v, want %v", year, got, want)
+		}
+	}
+}
+
+func TestEaster(t *testing.T) {
+	tests := map[int]string{
+		2024: "2024-03-31",
+		2025: "2025-04-20",
+		2026: "2026-04-05",
+		2038: "2038-04-25", // latest possible date
+		2285: "2285-03-22", // earliest possible date
+	}
+	for year, want := range tests {
+		if got := easter(year).Format(time.DateOnly); got != want {
+			t.Errorf("easter(%d) = %s, want %s", year, got, want)
+		}
+	}
+}
+
+func TestNYSECalendarPastAnyFixedTable(t *testing.T) {
+	c := NewNYSECalendar()
+
+	thanksgiving := time.Date(2040, time.November, 22, 12, 0, 0, 0, c.loc)
+	if c.IsOpen(thanksgiving, AssetClassEquity) {
+		t.Errorf("IsOpen() = true on Thanksgiving 2040")
+	}
+	if !c.IsOpen(thanksgiving.AddDate(0, 0, 1), AssetClassEquity) {
+		t.Errorf("IsOpen() = false on the day after Thanksgiving 2040")
+	}
+	if !c.IsOpen(thanksgiving, AssetClassCrypto) {
+		t.Errorf("IsOpen() = false for crypto on a holiday")
+	}
+}
@@ -0,0 +1,68 @@
+package market
+
+import (
+	"backend/internal/auth"
+	"backend/internal/config"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Handler holds dependencies for market HTTP handlers.
+type Handler struct {
+	calendar *MarketCalendar
+	cfg      *config.Config
+}
+
+// NewHandler creates a new market handler.
+func NewHandler(calendar *MarketCalendar, cfg *config.Config) *Handler {
+	if calendar == nil {
+		log.Fatal("Market Handler: MarketCalendar cannot be nil")
+	}
+	if cfg == nil {
+		log.Fatal("Market Handler: Config cannot be nil")
+	}
+	return &Handler{calendar: calendar, cfg: cfg}
+}
+
+// --- Request/Response
+
+// SessionStatus describes whether a market is open and, for markets with sessions, when it next changes.
+type SessionStatus struct {
+	Open      bool       `json:"open"`
+	NextOpen  *time.Time `json:"nextOpen,omitempty"`  // only set while closed
+	NextClose *time.Time `json:"nextClose,omitempty"` // only set while open
+}
+
+type StatusResponse struct {
+	Timestamp time.Time                `json:"timestamp"`
+	Markets   map[string]SessionStatus `json:"markets"`
+}
+
+// --- HTTP Handlers
+
+// Status reports whether each asset class can currently trade.
+// crypto is left out while its feature flag is off.
+// GET /api/market/status
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	equity := SessionStatus{Open: h.calendar.IsOpen(now, AssetClassEquity)}
+	if equity.Open {
+		nextClose := h.calendar.NextClose(now).UTC()
+		equity.NextClose = &nextClose
+	} else {
+		nextOpen := h.calendar.NextOpen(now).UTC()
+		equity.NextOpen = &nextOpen
+	}
+
+	markets := map[string]SessionStatus{AssetClassEquity: equity}
+	if h.cfg.FeatureEnabled(config.FeatureCrypto) {
+		markets[AssetClassCrypto] = SessionStatus{Open: h.calendar.IsOpen(now, AssetClassCrypto)}
+	}
+
+	auth.RespondWithJSON(w, http.StatusOK, StatusResponse{
+		Timestamp: now,
+		Markets:   markets,
+	})
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressionLevel trades speed for size, 5 is where gains flatten out for JSON.
+const compressionLevel = 5
+
+// compressibleTypes are the content types worth compressing. event streams are left out on
+// purpose: buffering would hold back events, and they are flushed per event anyway.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"text/plain":       true,
+	"text/csv":         true,
+}
+
+// Compress gzip- or deflate-encodes responses of a compressible type once they reach minSize
+// bytes, for clients that accept it. the body is buffered up to minSize to know whether it
+// gets there, so small responses go out unchanged. responses that already carry a
+// Content-Encoding and upgraded connections (websockets) are passed through.
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip, or deflate, from an Accept-Encoding header. "" means neither is
+// acceptable. q values only matter as far as q=0 refusing an encoding.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q > 0
+	}
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if on, listed := accepted[encoding]; on || (!listed && accepted["*"]) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter holds back the status and the first minSize bytes until it knows whether
+// the response gets compressed, see decide.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status     int
+	buf        []byte
+	decided    bool
+	compressor io.WriteCloser // nil when the response goes out as is
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the held back status and bytes, compressed when the response qualifies.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	if cw.compressible() {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			cw.compressor, _ = gzip.NewWriterLevel(cw.ResponseWriter, compressionLevel)
+		} else {
+			cw.compressor, _ = flate.NewWriter(cw.ResponseWriter, compressionLevel)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.compressor != nil {
+		_, err := cw.compressor.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	if len(cw.buf) < cw.minSize || cw.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+	return err == nil && compressibleTypes[mediaType]
+}
+
+// close sends a response still held back (it stayed under minSize) and ends the compressed stream.
+// a handler that wrote nothing at all is left alone, so outer middleware can still respond.
+func (cw *compressWriter) close() {
+	if !cw.decided && cw.status != 0 {
+		_ = cw.decide()
+	}
+	if cw.compressor != nil {
+		_ = cw.compressor.Close()
+	}
+}
+
+// Flush sends whatever is buffered: a handler flushing is streaming, so waiting for minSize is pointless.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		_ = cw.decide()
+	}
+	if f, ok := cw.compressor.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("middleware: http.Hijacker is unavailable on the writer")
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
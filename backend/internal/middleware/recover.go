@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"backend/internal/auth"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// panicsTotal counts recovered handler panics, served with the other expvars on GET /api/admin/debug/vars.
+var panicsTotal = expvar.NewInt("panics_total")
+
+// Recoverer recovers handler panics, logs them with their stack through slog and answers
+// with the standard JSON error envelope. the stack never reaches the client.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// deliberate abort, net/http handles it silently
+				panic(rec)
+			}
+
+			panicsTotal.Add(1)
+			slog.ErrorContext(r.Context(), "panic recovered",
+				slog.String("panic", fmt.Sprint(rec)),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("request_id", chimiddleware.GetReqID(r.Context())),
+				slog.String("stack", string(debug.Stack())),
+			)
+
+			// too late for a proper response if the handler already started one
+			if ww.Status() == 0 {
+				auth.RespondWithAPIError(ww, http.StatusInternalServerError, auth.APIError{
+					Code:    auth.ErrorCodeInternal,
+					Message: "Internal server error",
+				})
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// readPanicsTotal reads panics_total the way the admin endpoint serves it.
+func readPanicsTotal(t *testing.T) int64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/debug/vars", nil))
+
+	var vars struct {
+		PanicsTotal *int64 `json:"panics_total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("decoding the expvars: %v", err)
+	}
+	if vars.PanicsTotal == nil {
+		t.Fatal("panics_total is missing from the expvars")
+	}
+	return *vars.PanicsTotal
+}
+
+func TestRecovererCountsPanics(t *testing.T) {
+	before := readPanicsTotal(t)
+
+	h := Recoverer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if after := readPanicsTotal(t); after != before+1 {
+		t.Fatalf("panics_total = %d after a panic, want %d", after, before+1)
+	}
+}
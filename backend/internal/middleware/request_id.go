@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// EchoRequestID sets the request id as the X-Request-Id response header, so clients can
+// quote it in bug reports and correlate retries. it must be mounted after chi's RequestID,
+// which takes the id from the incoming header when the client sent one.
+func EchoRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := chimiddleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(chimiddleware.RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
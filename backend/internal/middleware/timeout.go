@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"backend/internal/auth"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Timeout cancels the request context after timeout and, if the handler gave up without
+// writing a response, answers with a JSON 504 instead of chi's empty one.
+// handlers must watch the context (all db queries do) for the timeout to have an effect.
+// it can be nested: a tighter Timeout on a subrouter wins, and the outer one then sees
+// the response already written and leaves it alone.
+func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && ww.Status() == 0 {
+				auth.RespondWithAPIError(ww, http.StatusGatewayTimeout, auth.APIError{
+					Code:    auth.ErrorCodeTimeout,
+					Message: "Request timed out",
+				})
+			}
+		})
+	}
+}
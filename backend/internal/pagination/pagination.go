@@ -0,0 +1,69 @@
+package pagination
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// DefaultLimit is used when the request does not specify a limit.
+	DefaultLimit = 20
+	// MaxLimit caps the number of items a single page can return.
+	MaxLimit = 100
+)
+
+// ErrInvalidPage is returned when the limit or offset query params are malformed.
+var ErrInvalidPage = errors.New("limit and offset must be non-negative integers")
+
+// Page describes which slice of a result set to return.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// FromRequest reads the `limit` and `offset` query params of a request.
+// missing values fall back to DefaultLimit and 0, limits above MaxLimit are clamped.
+func FromRequest(r *http.Request) (Page, error) {
+	page := Page{Limit: DefaultLimit, Offset: 0}
+	q := r.URL.Query()
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return Page{}, ErrInvalidPage
+		}
+		page.Limit = min(limit, MaxLimit)
+	}
+
+	if raw := q.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return Page{}, ErrInvalidPage
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// Response is the common envelope for paginated list endpoints.
+type Response[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// NewResponse wraps a page of items together with the total count of items available.
+func NewResponse[T any](items []T, total int, page Page) Response[T] {
+	if items == nil {
+		items = []T{} // serialize as [] rather than null
+	}
+	return Response[T]{
+		Items:  items,
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"backend/internal/config"
+	"backend/internal/version"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Setup installs the global OpenTelemetry tracer provider, exporting spans over OTLP/HTTP
+// to cfg.OTLPEndpoint. when no endpoint is configured the global no-op provider stays in
+// place, so instrumented code costs next to nothing.
+// the returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	// incoming traceparent headers are honored either way, so traces stay connected across services
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpointURL, err := tracesURL(cfg.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL))
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(cfg.OTELServiceName),
+			semconv.ServiceVersion(version.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Tracing enabled, exporting spans to %s", endpointURL)
+
+	return provider.Shutdown, nil
+}
+
+// tracesPath is the OTLP/HTTP path of the traces signal.
+const tracesPath = "/v1/traces"
+
+// tracesURL turns the OTEL_EXPORTER_OTLP_ENDPOINT base URL into the traces URL, appending
+// /v1/traces to its path like the OpenTelemetry spec says for that variable.
+// WithEndpointURL uses the path as given, so a bare http://localhost:4318 would POST to "/".
+func tracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must be an absolute URL like http://localhost:4318, got %q", endpoint)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + tracesPath
+	return u.String(), nil
+}
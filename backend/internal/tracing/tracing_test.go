@@ -0,0 +1,30 @@
+package tracing
+
+import "testing"
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318/", "http://localhost:4318/v1/traces"},
+		{"https://collector.example.com/otlp", "https://collector.example.com/otlp/v1/traces"},
+	}
+	for _, tt := range tests {
+		got, err := tracesURL(tt.endpoint)
+		if err != nil {
+			t.Errorf("tracesURL(%q) returned error: %v", tt.endpoint, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("tracesURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+
+	for _, endpoint := range []string{"localhost:4318", "/v1/traces", "://bad"} {
+		if _, err := tracesURL(endpoint); err == nil {
+			t.Errorf("tracesURL(%q) should fail", endpoint)
+		}
+	}
+}
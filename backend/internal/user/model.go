@@ -5,13 +5,34 @@ import (
 	"time"
 )
 
+// roles a user can have.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// IsValidRole reports whether role is one of the known roles.
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
 // User represents a user in the system.
 type User struct {
-	ID           uuid.UUID `json:"id" db:"id"` // use string for flexibility (e.g. UUID)
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+	ID            uuid.UUID  `json:"id" db:"id"` // use string for flexibility (e.g. UUID)
+	Email         string     `json:"email" db:"email"`
+	PasswordHash  string     `json:"-" db:"password_hash"`
+	PasswordAlgo  string     `json:"-" db:"password_algo"` // "<algo>:<cost>" that produced PasswordHash, e.g. "bcrypt:10"
+	Role          string     `json:"role" db:"role"`
+	EmailVerified bool       `json:"emailVerified" db:"email_verified"`
+	DisplayName   string     `json:"displayName" db:"display_name"` // public name, the only identity shown to other users
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updatedAt" db:"updated_at"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty" db:"deleted_at"` // nil unless soft-deleted
+}
+
+// IsDeleted reports whether the user has been soft-deleted.
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
 }
 
 // NewUser contains information needed to create a new user.
@@ -0,0 +1,31 @@
+package version
+
+// build information, overridden at build time with -ldflags, e.g.:
+//
+//	go build -ldflags "-X backend/internal/version.Version=1.2.0 \
+//		-X backend/internal/version.Commit=$(git rev-parse --short HEAD) \
+//		-X backend/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/api
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// APIVersion is the version of the public HTTP API, bumped on breaking changes.
+const APIVersion = "v1"
+
+// Info is the build information as served to clients.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+	}
+}